@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azazeal/exit"
+
+	"github.com/soupedup/purgery/internal/bench"
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/common"
+	"github.com/soupedup/purgery/pkg/purgerytest"
+)
+
+// The set of ways the bench command enqueues purges.
+const (
+	viaAPI   = "api"
+	viaRedis = "redis"
+)
+
+// runBench implements the bench command, which load tests a purgery cluster.
+func runBench(args []string) (err error) {
+	fs := newFlagSet("bench", "")
+	newClient := apiFlags(fs)
+
+	var (
+		purges      = fs.Int("n", 1000, "number of purges to enqueue")
+		concurrency = fs.Int("c", 8, "number of concurrent enqueuers")
+		mix         = fs.String("mix", cache.ScopeHost, "comma-separated scope=weight mix of the purges")
+		hosts       = fs.Int("hosts", 10, "number of distinct hosts to spread the purges across")
+		via         = fs.String("via", viaAPI, "enqueue via the REST API (api) or straight into redis (redis)")
+		backend     = fs.String("backend", "127.0.0.1:8090", "address of the local fake backend the instances should target (empty disables it)")
+		wait        = fs.Duration("wait", 30*time.Second, "maximum time to wait for the instances to apply the purges")
+		asJSON      = fs.Bool("json", false, "print the report in JSON")
+	)
+
+	if err = parseFlags(fs, args, 0, 0); err != nil {
+		return
+	}
+
+	cfg := &bench.Config{
+		Purges:      *purges,
+		Concurrency: *concurrency,
+		Hosts:       *hosts,
+		Wait:        *wait,
+	}
+
+	if cfg.Mix, err = bench.ParseMix(*mix); err != nil || cfg.Purges < 1 || cfg.Concurrency < 1 {
+		fs.Usage()
+
+		return exit.Wrapf(common.ECUsage, "invalid -n, -c or -mix")
+	}
+
+	logger := cliLogger()
+
+	if cfg.Cache, err = openCache(logger); err != nil {
+		return fail(err)
+	}
+	defer cfg.Cache.Close()
+
+	switch *via {
+	case viaAPI:
+		cfg.Enqueue = bench.ViaAPI(newClient())
+	case viaRedis:
+		cfg.Enqueue = bench.ViaRedis(logger, cfg.Cache)
+	default:
+		fs.Usage()
+
+		return exit.Wrapf(common.ECUsage, "invalid -via (%q)", *via)
+	}
+
+	var b *purgerytest.Backend
+	if *backend != "" {
+		if b, err = purgerytest.ListenBackend(*backend); err != nil {
+			return fail(err)
+		}
+		defer b.Close()
+
+		fmt.Fprintf(os.Stderr, "fake backend listening on %s; point VARNISH_ADDR at it.\n", b.Addr())
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	rep, err := bench.Run(ctx, logger, cfg)
+	if rep == nil {
+		return fail(err)
+	}
+
+	var backendRequests int
+	if b != nil {
+		backendRequests = len(b.Requests())
+	}
+
+	if *asJSON {
+		return printJSON(struct {
+			*bench.Report
+			BackendRequests int `json:"backend_requests"`
+		}{rep, backendRequests})
+	}
+
+	fmt.Printf("enqueued:\t%d (%d failed) in %s\nthroughput:\t%.1f/s\nbackend:\t%d requests\n\n",
+		rep.Enqueued, rep.Failed, rep.Elapsed.Round(time.Millisecond), rep.Throughput, backendRequests)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INSTANCE\tAPPLIED\tDROPPED\tFAILURES\tP50\tP90\tP99\tMAX")
+	for _, r := range rep.Instances {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			r.Instance, r.Applied, r.Dropped, r.Failures, r.P50, r.P90, r.P99, r.Max)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azazeal/exit"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/common"
+	"github.com/soupedup/purgery/internal/env"
+	"github.com/soupedup/purgery/internal/log"
+	"github.com/soupedup/purgery/pkg/client"
+)
+
+// newFlagSet returns the flag set of the named command, whose usage line
+// lists the given arguments.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(common.AppName+" "+name, flag.ContinueOnError)
+	usage := strings.TrimSpace(fmt.Sprintf("usage: %s %s [flags] %s", common.AppName, name, args))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "%s\n\nflags:\n", usage)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parseFlags parses the given arguments and ensures the number of positional
+// arguments is within the given bounds; max < 0 denotes no upper bound.
+func parseFlags(fs *flag.FlagSet, args []string, min, max int) error {
+	if err := fs.Parse(args); err != nil {
+		return exit.Wrap(common.ECUsage, err) // already reported
+	}
+
+	if n := fs.NArg(); n < min || (max >= 0 && n > max) {
+		fs.Usage()
+
+		return exit.Wrapf(common.ECUsage, "unexpected number of arguments: %d", n)
+	}
+
+	return nil
+}
+
+// fail reports the given error and returns it.
+func fail(err error) error {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", common.AppName, err)
+
+	return err
+}
+
+// cliLogger returns the Logger of commands, which only logs warnings and
+// errors unless LOG_LEVEL says otherwise.
+func cliLogger() *zap.Logger {
+	logger := log.New("cli")
+	if os.Getenv("LOG_LEVEL") == "" {
+		logger = logger.WithOptions(zap.IncreaseLevel(zapcore.WarnLevel))
+	}
+
+	return logger
+}
+
+// apiFlags registers the flags which configure how the REST API is reached
+// and returns a function which builds the client they describe.
+func apiFlags(fs *flag.FlagSet) func() *client.Client {
+	api := os.Getenv("PURGERY_API")
+	if api == "" {
+		api = "http://localhost:3000"
+	}
+
+	var (
+		rootURL = fs.String("api", api, "root URL of the REST API (PURGERY_API)")
+		apiKey  = fs.String("key", os.Getenv("API_KEY"), "API key (API_KEY)")
+	)
+
+	return func() *client.Client {
+		return client.New(*rootURL, *apiKey)
+	}
+}
+
+// openCache returns a Cache which works on the Redis instance REDIS_URL points
+// to.
+func openCache(logger *zap.Logger) (*cache.Cache, error) {
+	pool, err := env.LoadRedis(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.New("", pool), nil
+}
+
+// runPurge implements the purge command, which requests that URLs be purged.
+func runPurge(args []string) (err error) {
+	fs := newFlagSet("purge", "[url ...]")
+	newClient := apiFlags(fs)
+	file := fs.String("f", "", "file listing URLs to purge, one per line (- for standard input)")
+
+	if err = parseFlags(fs, args, 0, -1); err != nil {
+		return
+	}
+
+	urls := fs.Args()
+	if *file != "" {
+		var fromFile []string
+		if fromFile, err = readURLs(*file); err != nil {
+			return fail(err)
+		}
+		urls = append(urls, fromFile...)
+	}
+
+	if len(urls) == 0 {
+		fs.Usage()
+
+		return exit.Wrapf(common.ECUsage, "no urls given")
+	}
+
+	c := newClient()
+	ctx := context.Background()
+
+	var failed int
+	for _, url := range urls {
+		id, err := c.Enqueue(ctx, url)
+		if err != nil {
+			failed++
+			_ = fail(err)
+
+			continue
+		}
+
+		fmt.Printf("%s\t%s\n", id, url)
+	}
+
+	if failed > 0 {
+		return fail(fmt.Errorf("failed enqueueing %d of %d purges", failed, len(urls)))
+	}
+
+	return nil
+}
+
+// readURLs returns the URLs the given file lists, skipping blank lines and
+// comments.
+func readURLs(file string) (urls []string, err error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		var f *os.File
+		if f, err = os.Open(file); err != nil {
+			return
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+
+	return urls, sc.Err()
+}
+
+// runStatus implements the status command, which reports the status of a
+// purge request on each instance.
+func runStatus(args []string) (err error) {
+	fs := newFlagSet("status", "<id>")
+	newClient := apiFlags(fs)
+	asJSON := fs.Bool("json", false, "print the status in JSON")
+
+	if err = parseFlags(fs, args, 1, 1); err != nil {
+		return
+	}
+
+	var st *client.Status
+	if st, err = newClient().Status(context.Background(), fs.Arg(0)); err != nil {
+		return fail(err)
+	}
+
+	if *asJSON {
+		return printJSON(st)
+	}
+
+	fmt.Printf("id:\t%s\nurl:\t%s\ntime:\t%s\n\n", st.ID, st.URL, st.Time.Format(time.RFC3339Nano))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INSTANCE\tSTATE\tERROR")
+	for _, is := range st.Instances {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", is.Instance, is.State, is.Error)
+	}
+
+	return tw.Flush()
+}
+
+// runInspect implements the inspect command, which reports the state of the
+// purge stream and its consumers.
+func runInspect(args []string) (err error) {
+	fs := newFlagSet("inspect", "")
+	asJSON := fs.Bool("json", false, "print the details in JSON")
+
+	if err = parseFlags(fs, args, 0, 0); err != nil {
+		return
+	}
+
+	logger := cliLogger()
+
+	var c *cache.Cache
+	if c, err = openCache(logger); err != nil {
+		return fail(err)
+	}
+	defer c.Close()
+
+	info, ok := c.Inspect(logger)
+	if !ok {
+		return fail(errInspect)
+	}
+
+	if *asJSON {
+		return printJSON(info)
+	}
+
+	fmt.Printf("length:\t%d\nfirst:\t%s\nlast:\t%s\nquarantined:\t%d\n\n",
+		info.Length, info.FirstID, info.LastID, info.Quarantined)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INSTANCE\tCHECKPOINT\tBEHIND\tLAG")
+	for _, ii := range info.Instances {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", ii.Instance, ii.Checkpoint, ii.Behind, ii.Lag)
+	}
+
+	return tw.Flush()
+}
+
+var errInspect = errors.New("failed inspecting the purge stream")
+
+// runReplay implements the replay command, which rewinds the checkpoint of an
+// instance.
+func runReplay(args []string) (err error) {
+	fs := newFlagSet("replay", "")
+	var (
+		instance = fs.String("instance", "", "ID (TARGET_ID) of the target to rewind")
+		from     = fs.String("from", "", "ID of the first purge stream entry to reapply")
+	)
+
+	if err = parseFlags(fs, args, 0, 0); err != nil {
+		return
+	}
+
+	if *instance == "" || !cache.IsStreamID(*from) {
+		fs.Usage()
+
+		return exit.Wrapf(common.ECUsage, "both -instance and a valid -from are required")
+	}
+
+	logger := cliLogger()
+
+	var c *cache.Cache
+	if c, err = openCache(logger); err != nil {
+		return fail(err)
+	}
+	defer c.Close()
+
+	if !c.Replay(logger, *instance, *from) {
+		return fail(errReplay)
+	}
+
+	fmt.Printf("%s will reapply purges from %s onwards.\n", *instance, *from)
+
+	return nil
+}
+
+var errReplay = errors.New("failed rewinding the checkpoint")
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
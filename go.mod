@@ -1,6 +1,6 @@
 module github.com/soupedup/purgery
 
-go 1.17
+go 1.18
 
 require (
 	github.com/azazeal/exit v0.1.2
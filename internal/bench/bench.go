@@ -0,0 +1,375 @@
+// Package bench implements the load generator the bench command runs against
+// a purgery cluster.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/pkg/client"
+)
+
+// EnqueueFunc is the set of functions capable of enqueueing purge requests.
+// Implementations return the ID of the stream entry which carries the purge
+// request.
+type EnqueueFunc func(ctx context.Context, pr *cache.PurgeRequest) (string, error)
+
+// ViaAPI returns an EnqueueFunc which enqueues via the REST API the given
+// Client targets.
+func ViaAPI(c *client.Client) EnqueueFunc {
+	return func(ctx context.Context, pr *cache.PurgeRequest) (string, error) {
+		id, err := c.Submit(ctx, &client.PurgeRequest{
+			URL:     pr.URL,
+			Scope:   pr.Scope,
+			Pattern: pr.Pattern,
+			Keys:    pr.Keys,
+			Reason:  pr.Reason,
+		})
+		if err == nil && id == "" {
+			err = errNoID
+		}
+
+		return id, err
+	}
+}
+
+var errNoID = errors.New("bench: the api reported no entry id")
+
+// ViaRedis returns an EnqueueFunc which enqueues straight into the purge
+// stream of the given Cache.
+func ViaRedis(logger *zap.Logger, c *cache.Cache) EnqueueFunc {
+	return func(_ context.Context, pr *cache.PurgeRequest) (string, error) {
+		id, ok := c.EnqueuePurgeRequest(logger, pr)
+		if !ok {
+			return "", errEnqueue
+		}
+
+		return id, nil
+	}
+}
+
+var errEnqueue = errors.New("bench: failed enqueueing")
+
+// Config wraps the configuration of a benchmark.
+type Config struct {
+	// Purges holds the number of purges to enqueue.
+	Purges int
+
+	// Concurrency holds the number of concurrent enqueuers.
+	Concurrency int
+
+	// Mix holds the relative weight of each scope among the enqueued purges.
+	Mix map[string]int
+
+	// Hosts holds the number of distinct hosts the purges are spread across.
+	Hosts int
+
+	// Wait holds the maximum time to wait for the instances to apply the
+	// purges for.
+	Wait time.Duration
+
+	// Enqueue enqueues the purges.
+	Enqueue EnqueueFunc
+
+	// Cache is where the instances report their results.
+	Cache *cache.Cache
+}
+
+// ParseMix parses the given scope=weight,... mix.
+func ParseMix(s string) (map[string]int, error) {
+	mix := map[string]int{}
+
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		scope, weight := kv[0], 1
+		if len(kv) == 2 {
+			var err error
+			if weight, err = strconv.Atoi(kv[1]); err != nil || weight < 0 {
+				return nil, fmt.Errorf("bench: invalid weight (%q)", part)
+			}
+		}
+
+		if !cache.IsValidScope(scope) {
+			return nil, fmt.Errorf("bench: invalid scope (%q)", scope)
+		}
+		mix[scope] += weight
+	}
+
+	return mix, nil
+}
+
+// Report wraps the outcome of a benchmark.
+type Report struct {
+	// Enqueued holds the number of purges enqueued.
+	Enqueued int `json:"enqueued"`
+
+	// Failed holds the number of purges which failed enqueueing.
+	Failed int `json:"failed"`
+
+	// Elapsed holds the time enqueueing took.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Throughput holds the number of purges enqueued per second.
+	Throughput float64 `json:"throughput"`
+
+	// Instances holds the report of each instance which applied purges.
+	Instances []InstanceReport `json:"instances"`
+}
+
+// InstanceReport wraps the outcome of a benchmark on an instance.
+type InstanceReport struct {
+	// Instance holds the ID of the instance.
+	Instance string `json:"instance"`
+
+	// Applied holds the number of purges the instance applied.
+	Applied int `json:"applied"`
+
+	// Dropped holds the number of purges the instance dropped.
+	Dropped int `json:"dropped"`
+
+	// Failures holds the number of failed attempts to apply purges.
+	Failures int `json:"failures"`
+
+	// The enqueue-to-purge latency percentiles of the applied purges.
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// Run runs the benchmark the given Config describes.
+func Run(ctx context.Context, logger *zap.Logger, cfg *Config) (*Report, error) {
+	cursor, ok := cfg.Cache.LatestEventCursor(logger)
+	if !ok {
+		return nil, errCursor
+	}
+
+	prs := generate(cfg)
+
+	var (
+		mu      sync.Mutex
+		entries = make(map[string]time.Time, len(prs))
+		rep     = new(Report)
+		jobs    = make(chan *cache.PurgeRequest)
+		wg      sync.WaitGroup
+	)
+
+	started := time.Now()
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for pr := range jobs {
+				id, err := cfg.Enqueue(ctx, pr)
+
+				mu.Lock()
+				if err != nil {
+					rep.Failed++
+
+					logger.Warn("failed enqueueing.", zap.Error(err))
+				} else {
+					rep.Enqueued++
+					entries[id] = time.Time{}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, pr := range prs {
+		select {
+		case jobs <- pr:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	rep.Elapsed = time.Since(started)
+	if rep.Elapsed > 0 {
+		rep.Throughput = float64(rep.Enqueued) / rep.Elapsed.Seconds()
+	}
+
+	rep.Instances = collect(ctx, logger, cfg, cursor, entries)
+
+	return rep, ctx.Err()
+}
+
+var errCursor = errors.New("bench: failed reading the event cursor")
+
+// generate returns the purge requests the given Config describes.
+func generate(cfg *Config) []*cache.PurgeRequest {
+	scopes := make([]string, 0, len(cfg.Mix))
+	total := 0
+	for scope, weight := range cfg.Mix {
+		if weight > 0 {
+			scopes = append(scopes, scope)
+			total += weight
+		}
+	}
+	sort.Strings(scopes) // keeps runs reproducible
+
+	hosts := cfg.Hosts
+	if hosts < 1 {
+		hosts = 1
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	prs := make([]*cache.PurgeRequest, cfg.Purges)
+	for i := range prs {
+		scope := cache.ScopeHost
+		if total > 0 {
+			n := rnd.Intn(total)
+			for _, s := range scopes {
+				if n -= cfg.Mix[s]; n < 0 {
+					scope = s
+
+					break
+				}
+			}
+		}
+
+		pr := &cache.PurgeRequest{
+			URL:    fmt.Sprintf("http://bench-%d.purgery.test/%s/%d", i%hosts, nonce, i),
+			Scope:  scope,
+			Reason: "bench",
+		}
+
+		switch scope {
+		case cache.ScopeRegex:
+			pr.Pattern = "^/" + nonce + "/"
+		case cache.ScopeXKey:
+			pr.Keys = []string{"bench-" + nonce}
+		}
+
+		prs[i] = pr
+	}
+
+	return prs
+}
+
+// collect waits for the instances to report the outcome of the given entries
+// and returns the report of each instance.
+func collect(ctx context.Context, logger *zap.Logger, cfg *Config, cursor cache.EventCursor, entries map[string]time.Time) []InstanceReport {
+	var (
+		reports   = map[string]*InstanceReport{}
+		latencies = map[string][]time.Duration{}
+		finished  = map[string]map[string]bool{} // entries, by instance
+	)
+
+	for id := range entries {
+		if ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64); err == nil {
+			entries[id] = time.UnixMilli(ms)
+		}
+	}
+
+	deadline := time.Now().Add(cfg.Wait)
+	for ctx.Err() == nil && time.Now().Before(deadline) && !done(finished, len(entries)) {
+		events, ok := cfg.Cache.Events(logger, cursor)
+		if !ok {
+			time.Sleep(time.Second)
+
+			continue
+		}
+
+		for _, e := range events {
+			cursor = e.Cursor
+
+			enqueued, ours := entries[e.Entry]
+			if !ours || e.Type == cache.EventEnqueued {
+				continue
+			}
+
+			r := reports[e.Instance]
+			if r == nil {
+				r = &InstanceReport{Instance: e.Instance}
+				reports[e.Instance] = r
+				finished[e.Instance] = map[string]bool{}
+			}
+
+			if e.Type == cache.EventFailed {
+				// failed purges are retried
+				r.Failures++
+
+				continue
+			}
+
+			if finished[e.Instance][e.Entry] {
+				continue
+			}
+			finished[e.Instance][e.Entry] = true
+
+			if e.Type == cache.EventDropped {
+				r.Dropped++
+
+				continue
+			}
+
+			r.Applied++
+			latencies[e.Instance] = append(latencies[e.Instance], e.Time.Sub(enqueued))
+		}
+	}
+
+	ret := make([]InstanceReport, 0, len(reports))
+	for instance, r := range reports {
+		l := latencies[instance]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+
+		r.P50, r.P90, r.P99 = percentile(l, 50), percentile(l, 90), percentile(l, 99)
+		if len(l) > 0 {
+			r.Max = l[len(l)-1]
+		}
+
+		ret = append(ret, *r)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Instance < ret[j].Instance })
+
+	return ret
+}
+
+// done reports whether each instance which has reported any outcome has
+// finished with all n entries.
+func done(finished map[string]map[string]bool, n int) bool {
+	if len(finished) == 0 {
+		return n == 0
+	}
+
+	for _, entries := range finished {
+		if len(entries) < n {
+			return false
+		}
+	}
+
+	return true
+}
+
+// percentile returns the p-th percentile of the given sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	i := (len(sorted)*p + 99) / 100
+	if i > 0 {
+		i--
+	}
+
+	return sorted[i]
+}
@@ -0,0 +1,77 @@
+package bench
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+func TestParseMix(t *testing.T) {
+	cases := []struct {
+		in  string
+		exp map[string]int
+		err bool
+	}{
+		0: {in: "", exp: map[string]int{}},
+		1: {in: "host", exp: map[string]int{"host": 1}},
+		2: {in: "host=70, exact=30", exp: map[string]int{"host": 70, "exact": 30}},
+		3: {in: "bogus=1", err: true},
+		4: {in: "host=-1", err: true},
+		5: {in: "host=a", err: true},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			got, err := ParseMix(kase.in)
+			if kase.err {
+				assert.Error(t, err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, kase.exp, got)
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	prs := generate(&Config{
+		Purges: 1000,
+		Mix:    map[string]int{cache.ScopeHost: 1, cache.ScopeRegex: 1, cache.ScopeXKey: 2},
+		Hosts:  3,
+	})
+
+	got := map[string]int{}
+	hosts := map[string]bool{}
+	for _, pr := range prs {
+		got[pr.Scope]++
+		assert.Equal(t, pr.Scope == cache.ScopeRegex, pr.Pattern != "")
+		assert.Equal(t, pr.Scope == cache.ScopeXKey, len(pr.Keys) == 1)
+
+		u := pr.URL[len("http://"):]
+		hosts[u[:len("bench-0.purgery.test")]] = true
+	}
+
+	assert.Len(t, got, 3)
+	assert.InDelta(t, 500, got[cache.ScopeXKey], 75)
+	assert.Len(t, hosts, 3)
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+	assert.Equal(t, time.Duration(50), percentile(sorted, 50))
+	assert.Equal(t, time.Duration(99), percentile(sorted, 99))
+	assert.Equal(t, time.Duration(7), percentile([]time.Duration{7}, 99))
+}
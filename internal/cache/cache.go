@@ -3,7 +3,7 @@ package cache
 
 import (
 	"errors"
-	"fmt"
+	"time"
 
 	"github.com/azazeal/exit"
 	"github.com/gomodule/redigo/redis"
@@ -32,8 +32,11 @@ func New(purgeryID string, pool *redis.Pool) *Cache {
 
 // Cache wraps the functionality of our redis client.
 type Cache struct {
+	fence     int64 // fencing token of the target lease, if ever held
 	redis     *redis.Pool
 	purgeryID string
+	target    string
+	start     StartPosition
 }
 
 // Ping pings the Redis instance the Cache is configured to connect to.
@@ -67,141 +70,200 @@ func (c *Cache) Close() error {
 	return c.redis.Close()
 }
 
-var checkpointScript = redis.NewScript(2, `
-	local at = redis.call('TIME')
-	local ms = at[2] - (at[2] % 1000)
-	ms = (at[1] * 1000) + (ms / 1000)
+// checkpointTTL denotes the time checkpoints survive for once their instance
+// stops consuming the purge stream.
+const checkpointTTL = 24 * time.Hour
+
+// checkpointScript returns the checkpoint KEYS[2] holds, along with 0, or 1 in
+// case it had to be initialized, in which case it's set according to the start
+// position ARGV[1] & ARGV[2] denote. Checkpoints are rather taken over from the
+// legacy checkpoint KEYS[3], i.e. one kept before checkpoints were keyed to
+// targets, in case it exists, and 2 is returned. Either way, the checkpoint
+// expires in ARGV[3] seconds.
+var checkpointScript = redis.NewScript(3, `
+	local cp = redis.call("GET", KEYS[2])
+	if cp then
+		redis.call("EXPIRE", KEYS[2], ARGV[3])
+
+		return {cp, 0}
+	end
 
-	local cp = ms .. "-0"
+	if KEYS[3] ~= KEYS[2] then
+		cp = redis.call("GET", KEYS[3])
+		if cp then
+			redis.call("SET", KEYS[2], cp, "EX", ARGV[3])
+			redis.call("DEL", KEYS[3])
 
-	if not redis.call("SET", KEYS[2], cp, "EX", 86400, "NX") then
-		-- key existed; read what's in it and use it as the checkpoint
-		cp = redis.call("GET", KEYS[2])
+			return {cp, 2}
+		end
 	end
 
-	return cp
+	if ARGV[1] == "id" then
+		cp = ARGV[2]
+	else
+		local at = redis.call('TIME')
+		local ms = at[2] - (at[2] % 1000)
+		ms = (at[1] * 1000) + (ms / 1000)
+
+		if ARGV[1] == "ago" then
+			ms = math.max(0, ms - tonumber(ARGV[2]))
+		end
+
+		cp = ms .. "-0"
+	end
+
+	redis.call("SET", KEYS[2], cp, "EX", ARGV[3])
+
+	return {cp, 1}
 `)
 
+// checkpointsPrefix prefixes the keys of instance checkpoints.
+const checkpointsPrefix = keyspace + "checkpoints:"
+
 func (c *Cache) checkpointKey() string {
-	return fmt.Sprintf("%scheckpoints:%s", keyspace, c.purgeryID)
+	return checkpointsPrefix + c.Target()
 }
 
-func (c *Cache) checkpoint(logger *zap.Logger, conn redis.Conn) string {
-	logger.Debug("fetching checkpoint ...")
+// legacyCheckpointKey returns the key of the checkpoint the Cache kept before
+// checkpoints were keyed to targets.
+func (c *Cache) legacyCheckpointKey() string {
+	return checkpointsPrefix + c.purgeryID
+}
 
-	cp, err := redis.String(checkpointScript.Do(conn, stream, c.checkpointKey()))
-	if err != nil {
-		logger.Warn("failed loading checkpoint.",
-			zap.Error(err))
+// SetTarget sets the ID of the cache target the Cache consumes the purge stream
+// for. Checkpoints and results are keyed to it, so that processes which
+// replace one another continue from where their predecessors left off.
+func (c *Cache) SetTarget(target string) {
+	c.target = target
+}
 
-		return ""
+// Target returns the ID of the cache target the Cache consumes the purge stream
+// for, which defaults to the ID of the Cache.
+func (c *Cache) Target() string {
+	if c.target == "" {
+		return c.purgeryID
 	}
 
-	logger.Debug("checkpoint fetched.",
-		log.Checkpoint(cp))
-
-	return cp
+	return c.target
 }
 
-// Next returns the next url to be purged or empty strings in case such
-// a URL does not exist yet.
-func (c *Cache) Next(logger *zap.Logger) (cp, url string, ok bool) {
-	conn := c.redis.Get()
-	defer conn.Close()
-
-	if cp = c.checkpoint(logger, conn); cp == "" {
-		return
-	}
+// SetStartPosition sets where the Cache starts consuming the purge stream from
+// in case it has no checkpoint.
+func (c *Cache) SetStartPosition(sp StartPosition) {
+	c.start = sp
+}
 
-	logger.Debug("xreading ...")
+func (c *Cache) checkpoint(logger *zap.Logger, conn redis.Conn) string {
+	logger.Debug("fetching checkpoint ...")
 
-	ret, err := redis.Values(conn.Do("XREAD",
-		"COUNT", 1,
-		"BLOCK", 1000,
-		"STREAMS", stream,
-		cp,
-	))
+	args := append([]interface{}{stream, c.checkpointKey(), c.legacyCheckpointKey()}, c.start.scriptArgs()...)
+	args = append(args, int(checkpointTTL/time.Second))
 
-	switch err {
-	default:
-		logger.Warn("failed xreading.",
+	vals, err := redis.Values(checkpointScript.Do(conn, args...))
+	if err != nil {
+		logger.Warn("failed loading checkpoint.",
 			zap.Error(err))
-	case redis.ErrNil:
-		ok = true
 
-		logger.Debug("nothing xread.")
-	case nil:
-		ok = true
+		return ""
+	}
 
-		msg := ret[0].([]interface{})[1].([]interface{})[0].([]interface{})
-		cp = string(msg[0].([]byte))
+	var (
+		cp    string
+		state int
+	)
+	if _, err = redis.Scan(vals, &cp, &state); err != nil {
+		logger.Warn("failed decoding checkpoint.",
+			zap.Error(err))
 
-		pairs := msg[1].([]interface{})
-		url = string(pairs[1].([]byte))
+		return ""
+	}
 
-		logger.Info("xread.",
-			log.URL(url),
-			log.Checkpoint(cp),
-		)
+	switch state {
+	case 1:
+		logger.Info("no checkpoint found; initialized one.",
+			zap.Stringer("start", c.start),
+			log.Checkpoint(cp))
+	case 2:
+		logger.Info("took over legacy checkpoint.",
+			zap.String("process", c.purgeryID),
+			log.Checkpoint(cp))
+	default:
+		logger.Debug("checkpoint fetched.",
+			log.Checkpoint(cp))
 	}
 
-	return
+	return cp
 }
 
-// Store saves the given value as the Cache's checkpoint.
-func (c *Cache) Store(logger *zap.Logger, checkpoint string) bool {
-	conn := c.redis.Get()
-	defer conn.Close()
+// The set of purge scopes.
+const (
+	// ScopeHost denotes purges which apply to every object of the host of the
+	// purged URL. It's the scope of purge requests which don't specify one.
+	ScopeHost = "host"
 
-	logger = logger.With(log.Checkpoint(checkpoint))
-	logger.Info("storing checkpoint ...")
+	// ScopeExact denotes purges which apply to the object of the purged URL.
+	ScopeExact = "exact"
 
-	res, err := redis.String(conn.Do("SET",
-		c.checkpointKey(), checkpoint,
-		"EX", 60,
-	))
+	// ScopePrefix denotes purges which apply to every object whose URL starts
+	// with the path (and query) of the purged URL, on the same host.
+	ScopePrefix = "prefix"
 
-	switch {
-	case err != nil:
-		logger.Error("failed storing checkpoint.",
-			zap.Error(err))
+	// ScopeRegex denotes purges which apply to every object whose URL matches
+	// the regular expression of the purge request, on the host of the purged
+	// URL.
+	ScopeRegex = "regex"
 
-		return false
-	case res != "OK":
-		logger.Warn("wrong redis SET response.",
-			zap.String("response", res))
+	// ScopeXKey denotes purges which apply to every object tagged, via the
+	// xkey vmod, with any of the keys of the purge request.
+	ScopeXKey = "xkey"
+)
 
-		return false
-	default:
-		logger.Debug("checkpoint stored.")
+// Scopes holds the set of purge scopes.
+var Scopes = []string{
+	ScopeHost,
+	ScopeExact,
+	ScopePrefix,
+	ScopeRegex,
+	ScopeXKey,
+}
 
-		return true
+// IsValidScope reports whether the given scope is a valid one.
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
 	}
+
+	return false
 }
 
-// EnqueuePurgeRequest enqueues a purge request for the given URL.
-func (c *Cache) EnqueuePurgeRequest(logger *zap.Logger, url string) bool {
+// EnqueuePurgeRequest enqueues the given purge request and returns the ID of
+// the stream entry which carries it.
+func (c *Cache) EnqueuePurgeRequest(logger *zap.Logger, pr *PurgeRequest) (id string, ok bool) {
 	conn := c.redis.Get()
 	defer conn.Close()
 
-	logger = logger.With(log.URL(url))
+	logger = logger.With(log.URL(pr.URL), zap.String("requester", pr.Requester))
 	logger.Info("enqueueing purge request ...")
 
-	id, err := redis.String(conn.Do("XADD",
-		stream,
-		"MINID", "~", "0-0",
-		"*",
-		"url", url,
-	))
+	if pr.CreatedAt == nil {
+		now := time.Now().UTC()
+		pr.CreatedAt = &now
+	}
+
+	args := append(redis.Args{}.Add(stream, "MINID", "~", "0-0", "*"),
+		pr.args()...)
 
+	id, err := redis.String(conn.Do("XADD", args...))
 	if err != nil {
 		logger.Error("failed enqueueing purge request.",
 			zap.Error(err))
 
-		return false
+		return "", false
 	}
 
 	logger.Debug("enqueued purge request.", zap.String("id", id))
 
-	return true
+	return id, true
 }
@@ -0,0 +1,413 @@
+package cache
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/log"
+)
+
+// The defaults of the policy Consumers write their checkpoint back by.
+const (
+	// DefaultFlushCount denotes the default number of times a checkpoint
+	// advances before it's written back.
+	DefaultFlushCount = 100
+
+	// DefaultFlushInterval denotes the default time a checkpoint which has
+	// advanced is held before it's written back.
+	DefaultFlushInterval = time.Second
+
+	// refreshInterval denotes how often idle Consumers write their checkpoint
+	// back, which keeps it from expiring and picks up rewinds.
+	refreshInterval = 10 * time.Second
+)
+
+// Consumer consumes the purge stream on behalf of a Cache.
+//
+// Consumers keep their checkpoint in memory, read the purge stream over a
+// connection of their own and write their checkpoint back in batches.
+//
+// Instances of Consumer aren't safe for concurrent use.
+type Consumer struct {
+	cache *Cache
+	conn  redis.Conn // dedicated; checked out of the pool as required
+
+	cp         string    // in memory
+	written    string    // as last written back
+	writtenAt  time.Time // when the checkpoint was last written back
+	advances   int       // since the checkpoint was last written back
+	advancedAt time.Time // when the checkpoint first advanced since written back
+
+	flushCount    int
+	flushInterval time.Duration
+}
+
+// NewConsumer returns a Consumer which writes its checkpoint back once it has
+// advanced flushCount times, or flushInterval after it first advanced,
+// whichever comes first.
+//
+// Rewinds of the checkpoint (see Replay) are picked up when the checkpoint is
+// next written back.
+func (c *Cache) NewConsumer(flushCount int, flushInterval time.Duration) *Consumer {
+	if flushCount < 1 {
+		flushCount = 1
+	}
+
+	return &Consumer{
+		cache:         c,
+		flushCount:    flushCount,
+		flushInterval: flushInterval,
+	}
+}
+
+// Close writes back the checkpoint, if required, and releases the Consumer's
+// connection.
+func (cs *Consumer) Close(logger *zap.Logger) {
+	if cs.advances > 0 {
+		cs.Flush(logger)
+	}
+
+	if cs.conn != nil {
+		cs.conn.Close()
+		cs.conn = nil
+	}
+}
+
+// connection returns the Consumer's connection, replacing it in case it has
+// broken.
+func (cs *Consumer) connection() redis.Conn {
+	if cs.conn != nil && cs.conn.Err() != nil {
+		cs.conn.Close()
+		cs.conn = nil
+	}
+
+	if cs.conn == nil {
+		cs.conn = cs.cache.redis.Get()
+	}
+
+	return cs.conn
+}
+
+// checkpoint returns the in-memory checkpoint, which is loaded, or initialized
+// according to the Cache's start position, as required.
+func (cs *Consumer) checkpoint(logger *zap.Logger) string {
+	if cs.cp == "" {
+		if cs.cp = cs.cache.checkpoint(logger, cs.connection()); cs.cp != "" {
+			cs.written, cs.writtenAt = cs.cp, time.Now()
+		}
+	}
+
+	return cs.cp
+}
+
+// Next returns up to count of the purge stream entries which follow the
+// checkpoint, along with the ID of the last entry it read. The returned ID is
+// empty in case no such entries exist yet.
+//
+// Malformed entries are moved to the quarantine stream and left out of the
+// returned batch, though the returned ID still accounts for them.
+func (cs *Consumer) Next(logger *zap.Logger, count int) (last string, batch []Entry, ok bool) {
+	if !cs.flushDue(logger) {
+		return
+	}
+
+	cp := cs.checkpoint(logger)
+	if cp == "" {
+		return
+	}
+
+	logger.Debug("xreading ...")
+
+	conn := cs.connection()
+	ret, err := redis.Values(conn.Do("XREAD",
+		"COUNT", count,
+		"BLOCK", 1000,
+		"STREAMS", stream,
+		cp,
+	))
+
+	switch err {
+	default:
+		logger.Warn("failed xreading.",
+			zap.Error(err))
+
+		return "", nil, false
+	case redis.ErrNil:
+		logger.Debug("nothing xread.")
+
+		return "", nil, true
+	case nil:
+		break
+	}
+
+	streams, err := decodeXRead(ret)
+	if err == nil && len(streams) != 1 {
+		err = ErrMalformedReply
+	}
+	if err != nil {
+		logger.Error("failed decoding xread reply.",
+			zap.Error(err))
+
+		return "", nil, false
+	}
+
+	for _, v := range streams[0].entries {
+		e, err := decodePurgeEntry(v)
+		if err == nil {
+			logger.Info("xread.",
+				log.URL(e.URL),
+				zap.String("scope", e.Scope),
+				log.Checkpoint(e.ID),
+			)
+
+			last, batch = e.ID, append(batch, e)
+
+			continue
+		}
+
+		var ee *EntryError
+		if !errors.As(err, &ee) || ee.ID == "" {
+			logger.Error("failed decoding entry id.",
+				zap.Error(err))
+
+			break
+		}
+
+		if !cs.cache.quarantine(logger, conn, ee) {
+			break
+		}
+		cs.cache.RecordResult(logger, ee.ID, "", EventQuarantined, ee.Err)
+
+		last = ee.ID
+	}
+
+	// entries which follow those that could neither be decoded nor quarantined
+	// are read again
+	return last, batch, last != "" || len(streams[0].entries) == 0
+}
+
+// Store advances the checkpoint to the given entry, and writes it back in case
+// that's due.
+func (cs *Consumer) Store(logger *zap.Logger, checkpoint string) bool {
+	if cs.cp = checkpoint; cs.advances == 0 {
+		cs.advancedAt = time.Now()
+	}
+	cs.advances++
+
+	return cs.flushDue(logger)
+}
+
+// Jump advances the checkpoint to the given entry, i.e. past entries which were
+// skipped, and writes it back right away.
+func (cs *Consumer) Jump(logger *zap.Logger, checkpoint string) bool {
+	cs.cp = checkpoint
+	cs.advances++
+
+	return cs.Flush(logger)
+}
+
+// flushDue writes the checkpoint back in case that's due.
+func (cs *Consumer) flushDue(logger *zap.Logger) bool {
+	switch {
+	case cs.cp == "":
+		return true // not loaded yet
+	case cs.advances >= cs.flushCount,
+		cs.advances > 0 && time.Since(cs.advancedAt) >= cs.flushInterval,
+		time.Since(cs.writtenAt) >= refreshInterval:
+		return cs.Flush(logger)
+	default:
+		return true
+	}
+}
+
+// flushScript sets the checkpoint KEYS[1] to ARGV[1], for ARGV[2] seconds, and
+// returns 1, unless:
+//
+//   - ARGV[4] is a fencing token, which has been superseded as the one of the
+//     lease KEYS[2] & KEYS[3] (see leadershipScript), in which case it
+//     returns 0
+//   - the checkpoint is no longer ARGV[3], as it was last written, in which
+//     case it has been rewound and the script returns 2, along with it.
+var flushScript = redis.NewScript(3, `
+	if ARGV[4] ~= "0" then
+		if redis.call("GET", KEYS[2]) ~= ARGV[5] or redis.call("GET", KEYS[3]) ~= ARGV[4] then
+			return {0, ""}
+		end
+	end
+
+	local cp = redis.call("GET", KEYS[1])
+	if cp and cp ~= ARGV[3] then
+		redis.call("EXPIRE", KEYS[1], ARGV[2])
+
+		return {2, cp}
+	end
+
+	redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+
+	return {1, ""}
+`)
+
+// Flush writes the checkpoint back.
+func (cs *Consumer) Flush(logger *zap.Logger) bool {
+	if cs.cp == "" {
+		return true
+	}
+
+	c := cs.cache
+	lease := c.leadershipLease()
+	token := atomic.LoadInt64(&c.fence)
+
+	logger = logger.With(log.Checkpoint(cs.cp))
+	logger.Debug("storing checkpoint ...",
+		zap.Int("advances", cs.advances))
+
+	vals, err := redis.Values(flushScript.Do(cs.connection(),
+		c.checkpointKey(), leaseKey(lease), fenceKey(lease),
+		cs.cp, int(checkpointTTL/time.Second), cs.written, token, c.purgeryID))
+
+	var (
+		res    int
+		actual string
+	)
+	if err == nil {
+		_, err = redis.Scan(vals, &res, &actual)
+	}
+
+	switch {
+	case err != nil:
+		logger.Error("failed storing checkpoint.",
+			zap.Error(err))
+
+		return false
+	case res == 0:
+		logger.Warn("checkpoint fenced off; leadership has been lost.",
+			zap.Int64("token", token))
+
+		return false
+	case res == 2:
+		logger.Warn("checkpoint rewound elsewhere; resuming from it.",
+			zap.String("rewound", actual))
+
+		cs.cp = actual
+	default:
+		logger.Debug("checkpoint stored.")
+	}
+
+	cs.written, cs.writtenAt, cs.advances = cs.cp, time.Now(), 0
+
+	return true
+}
+
+// backlogScript returns the number of entries of the stream KEYS[1] which
+// follow ARGV[1], up to ARGV[2]. It answers from the IDs at the ends of the
+// stream, and its length, where it can and only counts entries as a last
+// resort.
+var backlogScript = redis.NewScript(1, `
+	if #redis.call("XRANGE", KEYS[1], "(" .. ARGV[1], "+", "COUNT", 1) == 0 then
+		return 0
+	end
+
+	local limit = tonumber(ARGV[2])
+	if #redis.call("XRANGE", KEYS[1], "-", ARGV[1], "COUNT", 1) == 0 then
+		-- every entry follows
+		return math.min(redis.call("XLEN", KEYS[1]), limit)
+	end
+
+	return #redis.call("XRANGE", KEYS[1], "(" .. ARGV[1], "+", "COUNT", limit)
+`)
+
+// Backlog returns the number of purge stream entries which follow the
+// checkpoint, up to limit.
+//
+// Backlogs are only counted when the checkpoint falls within the stream and
+// entries follow it; the cost of that is bounded by limit.
+func (cs *Consumer) Backlog(logger *zap.Logger, limit int) (n int, ok bool) {
+	cp := cs.checkpoint(logger)
+	if cp == "" {
+		return
+	}
+
+	n, err := redis.Int(backlogScript.Do(cs.connection(), stream, cp, limit))
+	if err != nil {
+		logger.Error("failed probing entries behind checkpoint.",
+			log.Checkpoint(cp),
+			zap.Error(err))
+
+		return 0, false
+	}
+
+	return n, true
+}
+
+// Pending returns up to count of the purge stream entries which follow the
+// checkpoint, along with the ID of the last entry it read, which is empty when
+// no such entries exist.
+//
+// Unlike Next, Pending doesn't block. Malformed entries are quarantined, as
+// they are by Next, and the returned ID stops short of those that couldn't be.
+func (cs *Consumer) Pending(logger *zap.Logger, count int) (last string, batch []Entry, ok bool) {
+	from := cs.checkpoint(logger)
+	if from == "" {
+		return
+	}
+
+	const chunk = 1000
+
+	conn := cs.connection()
+
+	var quarantined int
+	for len(batch)+quarantined < count {
+		n := count - len(batch) - quarantined
+		if n > chunk {
+			n = chunk
+		}
+
+		vals, err := redis.Values(conn.Do("XRANGE", stream, "("+from, "+", "COUNT", n))
+		if err != nil {
+			logger.Error("failed reading pending entries.",
+				zap.Error(err))
+
+			return "", nil, false
+		}
+
+		for _, v := range vals {
+			e, err := decodePurgeEntry(v)
+			if err == nil {
+				from, last, batch = e.ID, e.ID, append(batch, e)
+
+				continue
+			}
+
+			var ee *EntryError
+			if !errors.As(err, &ee) || ee.ID == "" {
+				logger.Error("failed decoding entry id.",
+					zap.Error(err))
+
+				return "", nil, false
+			}
+
+			if !cs.cache.quarantine(logger, conn, ee) {
+				return last, batch, true
+			}
+			cs.cache.RecordResult(logger, ee.ID, "", EventQuarantined, ee.Err)
+
+			from, last = ee.ID, ee.ID
+			quarantined++
+		}
+
+		if len(vals) < n {
+			break // caught up
+		}
+	}
+
+	if quarantined > 0 {
+		logger.Warn("quarantined malformed pending entries.",
+			zap.Int("count", quarantined))
+	}
+
+	return last, batch, true
+}
@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// countingConn implements a redis.Conn which counts the commands it's sent, and
+// the round trips they take, and serves a purge stream of endless entries,
+// which follow the ID they're read from, along with a checkpoint.
+type countingConn struct {
+	commands map[string]int
+	trips    int
+	pending  int    // commands sent but not yet flushed
+	seq      uint64 // of the last entry served
+	cp       string // as stored
+
+	rewound string // the checkpoint flushes report it has been rewound to
+	fenced  bool   // whether flushes report they have been fenced off
+}
+
+func newCountingConn() *countingConn {
+	return &countingConn{
+		commands: make(map[string]int),
+	}
+}
+
+func (c *countingConn) total() (n int) {
+	for _, v := range c.commands {
+		n += v
+	}
+
+	return
+}
+
+func (c *countingConn) Close() error { return nil }
+func (c *countingConn) Err() error   { return nil }
+
+func (c *countingConn) Flush() error                  { return errors.New("not implemented") }
+func (c *countingConn) Receive() (interface{}, error) { return nil, errors.New("not implemented") }
+
+func (c *countingConn) Send(cmd string, _ ...interface{}) error {
+	if cmd != "XADD" {
+		return errors.New("unexpected command: " + cmd)
+	}
+	c.commands[cmd]++
+	c.pending++
+
+	return nil
+}
+
+func (c *countingConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		// pipelines, and connection pools, flush via empty commands
+		if c.pending > 0 {
+			c.trips++
+			c.pending = 0
+		}
+
+		return nil, nil
+	}
+	c.commands[cmd]++
+	c.trips++
+
+	switch cmd {
+	case "EVALSHA":
+		switch args[0] {
+		case checkpointScript.Hash():
+			if c.cp == "" {
+				c.cp = "1-0"
+			}
+
+			return []interface{}{[]byte(c.cp), int64(0)}, nil
+		case flushScript.Hash():
+			switch {
+			case c.fenced:
+				return []interface{}{int64(0), []byte("")}, nil
+			case c.rewound != "":
+				c.cp, c.rewound = c.rewound, ""
+
+				return []interface{}{int64(2), []byte(c.cp)}, nil
+			}
+			c.cp = args[5].(string)
+
+			return []interface{}{int64(1), []byte("")}, nil
+		}
+	case "XREAD":
+		count := args[1].(int)
+		_, c.seq, _ = parseStreamID(args[6].(string))
+
+		entries := make([]interface{}, count)
+		for i := range entries {
+			c.seq++
+			entries[i] = entry(formatStreamID(1, c.seq),
+				"url", "http://example.com/"+strconv.FormatUint(c.seq, 10),
+				"v", "1",
+				"scope", ScopeExact)
+		}
+
+		return []interface{}{
+			[]interface{}{[]byte(stream), entries},
+		}, nil
+	}
+
+	return nil, errors.New("unexpected command: " + cmd)
+}
+
+func newCountingCache(conn *countingConn) *Cache {
+	return New("a", &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return conn, nil
+		},
+	})
+}
+
+func TestConsumer(t *testing.T) {
+	conn := newCountingConn()
+	cs := newCountingCache(conn).NewConsumer(3, time.Hour)
+
+	logger := zap.NewNop()
+	for i := 0; i < 5; i++ {
+		last, batch, ok := cs.Next(logger, 2)
+		require.True(t, ok)
+		require.Len(t, batch, 2)
+		assert.Equal(t, batch[1].ID, last)
+
+		require.True(t, cs.Store(logger, last))
+	}
+
+	// the checkpoint was loaded once and written back after every third batch
+	assert.Equal(t, map[string]int{"EVALSHA": 1 + 1, "XREAD": 5}, conn.commands)
+	assert.Equal(t, "1-6", conn.cp)
+
+	cs.Close(logger)
+	assert.Equal(t, "1-10", conn.cp)
+}
+
+func TestConsumerFlush(t *testing.T) {
+	cases := []struct {
+		rewound string
+		fenced  bool
+		ok      bool
+		stored  string // checkpoint, as stored
+		next    string // ID of the entry read next
+	}{
+		0: {ok: true, stored: "1-2", next: "1-3"},
+		1: {rewound: "1-1", ok: true, stored: "1-1", next: "1-2"},
+		2: {fenced: true, stored: "1-0", next: "1-3"},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			conn := newCountingConn()
+			cs := newCountingCache(conn).NewConsumer(DefaultFlushCount, time.Hour)
+
+			logger := zap.NewNop()
+			last, _, ok := cs.Next(logger, 2)
+			require.True(t, ok)
+			require.True(t, cs.Store(logger, last))
+
+			conn.rewound, conn.fenced = kase.rewound, kase.fenced
+			assert.Equal(t, kase.ok, cs.Flush(logger))
+			assert.Equal(t, kase.stored, conn.cp)
+
+			conn.fenced = false
+			_, batch, ok := cs.Next(logger, 1)
+			require.True(t, ok)
+			require.Len(t, batch, 1)
+			assert.Equal(t, kase.next, batch[0].ID)
+		})
+	}
+}
+
+func BenchmarkConsumer(b *testing.B) {
+	cases := []struct {
+		name       string
+		batch      int
+		flushCount int
+	}{
+		{"per-entry", 1, 1},
+		{"batched", 100, DefaultFlushCount},
+	}
+
+	for _, kase := range cases {
+		kase := kase
+
+		b.Run(kase.name, func(b *testing.B) {
+			conn := newCountingConn()
+			cs := newCountingCache(conn).NewConsumer(kase.flushCount, DefaultFlushInterval)
+			logger := zap.NewNop()
+
+			b.ResetTimer()
+			for purged := 0; purged < b.N; {
+				last, batch, ok := cs.Next(logger, kase.batch)
+				if !ok {
+					b.Fatal("failed consuming")
+				}
+
+				results := make([]Result, len(batch))
+				for i := range batch {
+					results[i] = Result{Entry: batch[i].ID, URL: batch[i].URL, Type: EventApplied}
+				}
+				cs.cache.RecordResults(logger, results)
+
+				if !cs.Store(logger, last) {
+					b.Fatal("failed consuming")
+				}
+				purged += len(batch)
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(conn.total())/float64(conn.seq), "cmds/purge")
+			b.ReportMetric(float64(conn.trips)/float64(conn.seq), "trips/purge")
+		})
+	}
+}
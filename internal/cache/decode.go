@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The set of errors decoding purge stream replies and entries may result in.
+var (
+	// ErrMalformedReply is returned when a stream reply isn't shaped as
+	// expected.
+	ErrMalformedReply = errors.New("cache: malformed stream reply")
+
+	// ErrMalformedID is returned when the ID of a stream entry is malformed.
+	ErrMalformedID = errors.New("cache: malformed stream entry id")
+
+	// ErrMalformedFields is returned when the fields of a stream entry aren't
+	// a list of field & value pairs.
+	ErrMalformedFields = errors.New("cache: malformed stream entry fields")
+
+	// ErrMissingURL is returned when a purge stream entry carries no url.
+	ErrMissingURL = errors.New("cache: purge stream entry carries no url")
+
+	// ErrInvalidVersion is returned when the envelope version of a purge
+	// stream entry isn't an integer.
+	ErrInvalidVersion = errors.New("cache: invalid purge stream entry version")
+
+	// ErrMissingPattern is returned when a regex-scoped purge stream entry
+	// carries no pattern.
+	ErrMissingPattern = errors.New("cache: regex-scoped purge stream entry carries no pattern")
+
+	// ErrMissingKeys is returned when an xkey-scoped purge stream entry
+	// carries no keys.
+	ErrMissingKeys = errors.New("cache: xkey-scoped purge stream entry carries no keys")
+)
+
+// EntryError is returned when a stream entry is malformed.
+type EntryError struct {
+	// ID holds the ID of the entry, or an empty string when it's malformed.
+	ID string
+
+	// Fields holds the raw fields and values of the entry, as far as they
+	// could be read.
+	Fields []string
+
+	// Err holds the reason the entry is malformed.
+	Err error
+}
+
+// Error implements error for EntryError.
+func (err *EntryError) Error() string {
+	return fmt.Sprintf("cache: malformed stream entry %q: %v", err.ID, err.Err)
+}
+
+// Unwrap implements errors.Unwrap for EntryError.
+func (err *EntryError) Unwrap() error {
+	return err.Err
+}
+
+// rawEntry wraps a decoded stream entry.
+type rawEntry struct {
+	id     string
+	fields []string // field & value pairs
+}
+
+// fieldMap returns the fields of the entry, keyed by name. Later occurrences
+// of a field override earlier ones.
+func (e *rawEntry) fieldMap() map[string]string {
+	m := make(map[string]string, len(e.fields)/2)
+	for i := 0; i+1 < len(e.fields); i += 2 {
+		m[e.fields[i]] = e.fields[i+1]
+	}
+
+	return m
+}
+
+// decodeEntry decodes the given stream entry, which is expected to be a
+// [id, [field, value, ...]] reply. Errors are of type *EntryError.
+func decodeEntry(v interface{}) (e rawEntry, err error) {
+	vals, ok := v.([]interface{})
+	if !ok || len(vals) != 2 {
+		return e, &EntryError{Err: ErrMalformedReply}
+	}
+
+	id, ok := vals[0].([]byte)
+	if !ok || !IsStreamID(string(id)) {
+		return e, &EntryError{Err: ErrMalformedID}
+	}
+	e.id = string(id)
+
+	fields, ok := vals[1].([]interface{})
+	if !ok {
+		return e, &EntryError{ID: e.id, Err: ErrMalformedFields}
+	}
+
+	e.fields = make([]string, 0, len(fields))
+	for _, f := range fields {
+		b, ok := f.([]byte)
+		if !ok {
+			return e, &EntryError{ID: e.id, Fields: e.fields, Err: ErrMalformedFields}
+		}
+		e.fields = append(e.fields, string(b))
+	}
+
+	if len(e.fields)%2 != 0 {
+		return e, &EntryError{ID: e.id, Fields: e.fields, Err: ErrMalformedFields}
+	}
+
+	return e, nil
+}
+
+// streamReply wraps the entries an XREAD reply carries for a stream.
+type streamReply struct {
+	key     string
+	entries []interface{}
+}
+
+// decodeXRead decodes the given XREAD reply, which is expected to be a
+// [[key, [entry, ...]], ...] one. Entries are left for decodeEntry to decode.
+func decodeXRead(v interface{}) ([]streamReply, error) {
+	streams, ok := v.([]interface{})
+	if !ok {
+		return nil, ErrMalformedReply
+	}
+
+	ret := make([]streamReply, 0, len(streams))
+	for _, s := range streams {
+		vals, ok := s.([]interface{})
+		if !ok || len(vals) != 2 {
+			return nil, ErrMalformedReply
+		}
+
+		key, ok := vals[0].([]byte)
+		if !ok {
+			return nil, ErrMalformedReply
+		}
+
+		entries, ok := vals[1].([]interface{})
+		if !ok {
+			return nil, ErrMalformedReply
+		}
+
+		ret = append(ret, streamReply{
+			key:     string(key),
+			entries: entries,
+		})
+	}
+
+	return ret, nil
+}
+
+// decodePurgeEntry decodes the given purge stream entry. The ID of the returned
+// Entry is set even when the entry is malformed, as long as it's readable.
+// Errors are of type *EntryError.
+func decodePurgeEntry(v interface{}) (e Entry, err error) {
+	raw, err := decodeEntry(v)
+	if e.ID = raw.id; err != nil {
+		return e, err
+	}
+
+	if ms, _, valid := parseStreamID(e.ID); valid {
+		e.Time = time.UnixMilli(int64(ms)).UTC()
+	}
+
+	if e.PurgeRequest, err = parsePurgeRequest(raw.fieldMap()); err != nil {
+		return e, &EntryError{ID: raw.id, Fields: raw.fields, Err: err}
+	}
+
+	return e, nil
+}
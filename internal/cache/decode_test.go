@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEntry(t *testing.T) {
+	cases := []struct {
+		in     interface{}
+		id     string
+		fields []string
+		err    error
+	}{
+		0: {
+			in:     entry("1-0", "url", "http://example.com/"),
+			id:     "1-0",
+			fields: []string{"url", "http://example.com/"},
+		},
+		1: {in: nil, err: ErrMalformedReply},
+		2: {in: []interface{}{[]byte("1-0")}, err: ErrMalformedReply},
+		3: {in: entry("foo", "url", "http://example.com/"), err: ErrMalformedID},
+		4: {in: []interface{}{int64(1), []interface{}{}}, err: ErrMalformedID},
+		5: {in: []interface{}{[]byte("1-0"), []byte("url")}, id: "1-0", err: ErrMalformedFields},
+		6: {in: entry("1-0", "foo"), id: "1-0", fields: []string{"foo"}, err: ErrMalformedFields},
+		7: {
+			in:     []interface{}{[]byte("1-0"), []interface{}{[]byte("url"), nil}},
+			id:     "1-0",
+			fields: []string{"url"},
+			err:    ErrMalformedFields,
+		},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			e, err := decodeEntry(kase.in)
+			assert.Equal(t, kase.id, e.id)
+
+			if kase.err == nil {
+				require.NoError(t, err)
+				assert.Equal(t, kase.fields, e.fields)
+
+				return
+			}
+
+			var ee *EntryError
+			require.True(t, errors.As(err, &ee))
+			assert.ErrorIs(t, err, kase.err)
+			assert.Equal(t, kase.id, ee.ID)
+			assert.Equal(t, kase.fields, ee.Fields)
+		})
+	}
+}
+
+func TestDecodeXRead(t *testing.T) {
+	got, err := decodeXRead([]interface{}{
+		[]interface{}{[]byte(stream), []interface{}{entry("1-0", "url", "a")}},
+	})
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, stream, got[0].key)
+	assert.Len(t, got[0].entries, 1)
+
+	for _, in := range []interface{}{
+		nil,
+		[]byte("foo"),
+		[]interface{}{nil},
+		[]interface{}{[]interface{}{[]byte(stream)}},
+		[]interface{}{[]interface{}{int64(1), []interface{}{}}},
+		[]interface{}{[]interface{}{[]byte(stream), []byte("foo")}},
+	} {
+		_, err := decodeXRead(in)
+		assert.Equal(t, ErrMalformedReply, err)
+	}
+}
+
+func FuzzDecodeReply(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{2, 2, 0, 3, '1', '-', '0', 2, 2, 0, 3, 'u', 'r', 'l', 0, 1, 'a'})
+	f.Add([]byte{2, 1, 2, 2, 0, 3, 'k', 'e', 'y', 2, 1, 2, 2, 0, 3, '1', '-', '0', 2, 1, 0, 3, 'f', 'o', 'o'})
+	f.Add([]byte{2, 2, 1, 7, 4})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		v, _ := buildReply(b, 0)
+
+		if e, err := decodeEntry(v); err != nil {
+			var ee *EntryError
+			require.True(t, errors.As(err, &ee))
+			require.Equal(t, e.id, ee.ID)
+			require.True(t, ee.ID == "" || IsStreamID(ee.ID))
+		} else {
+			require.True(t, IsStreamID(e.id))
+			require.Zero(t, len(e.fields)%2)
+
+			_, _ = parsePurgeRequest(e.fieldMap())
+		}
+
+		if streams, err := decodeXRead(v); err != nil {
+			require.Equal(t, ErrMalformedReply, err)
+		} else {
+			for _, s := range streams {
+				for _, e := range s.entries {
+					_, _ = decodeEntry(e)
+				}
+			}
+		}
+	})
+}
+
+func FuzzParsePurgeRequest(f *testing.F) {
+	f.Add("http://example.com/", "1", "", "", "", "")
+	f.Add("http://example.com/", "", "url", "", "a b", "{}")
+	f.Add("", "x", "tags", "xkey", " ", "{")
+
+	f.Fuzz(func(t *testing.T, url, v, kind, scope, keys, options string) {
+		pr, err := parsePurgeRequest(map[string]string{
+			"url":     url,
+			"v":       v,
+			"kind":    kind,
+			"scope":   scope,
+			"keys":    keys,
+			"options": options,
+		})
+
+		switch {
+		case url == "":
+			require.Equal(t, ErrMissingURL, err)
+
+			return
+		case err != nil:
+			require.Equal(t, ErrInvalidVersion, err)
+
+			return
+		}
+
+		require.NotEmpty(t, pr.Scope)
+		require.NotEmpty(t, pr.Kind)
+
+		// whatever decodes, encodes into an entry which decodes alike
+		args := pr.args()
+		fields := make(map[string]string, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			fields[args[i].(string)] = args[i+1].(string)
+		}
+
+		got, err := parsePurgeRequest(fields)
+		require.NoError(t, err)
+		require.Equal(t, pr.URL, got.URL)
+		require.Equal(t, pr.Scope, got.Scope)
+	})
+}
+
+func entry(id string, fields ...string) interface{} {
+	vals := make([]interface{}, 0, len(fields))
+	for _, f := range fields {
+		vals = append(vals, []byte(f))
+	}
+
+	return []interface{}{[]byte(id), vals}
+}
+
+// buildReply builds, out of the given bytes, a reply like those redigo
+// returns, and also returns the bytes it didn't consume.
+func buildReply(b []byte, depth int) (interface{}, []byte) {
+	if len(b) < 2 {
+		return nil, nil
+	}
+
+	typ, n, b := b[0]%5, int(b[1]), b[2:]
+	switch typ {
+	case 0: // bulk string
+		if n %= 32; n > len(b) {
+			n = len(b)
+		}
+
+		return b[:n], b[n:]
+	case 1:
+		return int64(n), b
+	case 2:
+		if depth == 4 {
+			return []interface{}{}, b
+		}
+
+		vals := make([]interface{}, n%8)
+		for i := range vals {
+			vals[i], b = buildReply(b, depth+1)
+		}
+
+		return vals, b
+	case 3:
+		return nil, b
+	default:
+		return redis.Error("ERR"), b
+	}
+}
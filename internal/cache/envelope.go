@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// EnvelopeVersion denotes the version of the purge stream entry schema this
+// package produces.
+//
+// Entries are decoded by field name and unknown fields are ignored, so that
+// producers and consumers may be upgraded independently. Entries which carry
+// no version (version 0) consist of nothing but a url field and denote
+// host-scoped purges.
+const EnvelopeVersion = 1
+
+// The set of purge request kinds, which classify purge requests by what they
+// target regardless of their scope.
+const (
+	// KindURL denotes purge requests which target URLs.
+	KindURL = "url"
+
+	// KindTags denotes purge requests which target tagged objects.
+	KindTags = "tags"
+
+	// KindHost denotes purge requests which target whole hosts.
+	KindHost = "host"
+)
+
+// kindOf returns the kind of purge requests of the given scope.
+func kindOf(scope string) string {
+	switch scope {
+	case "", ScopeHost:
+		return KindHost
+	case ScopeXKey:
+		return KindTags
+	default:
+		return KindURL
+	}
+}
+
+// scopeOf returns the scope of purge requests of the given kind which carry no
+// scope.
+func scopeOf(kind string) string {
+	switch kind {
+	case KindTags:
+		return ScopeXKey
+	case KindURL:
+		return ScopeExact
+	default:
+		return ScopeHost
+	}
+}
+
+// PurgeRequest wraps the details of a request to purge a URL.
+type PurgeRequest struct {
+	// URL holds the URL to purge.
+	URL string `json:"url"`
+
+	// Version holds the version of the envelope the purge request was
+	// decoded from.
+	Version int `json:"v,omitempty"`
+
+	// Kind holds the kind of the purge request.
+	Kind string `json:"kind,omitempty"`
+
+	// Scope holds the scope of the purge.
+	Scope string `json:"scope,omitempty"`
+
+	// Pattern holds the regular expression regex-scoped purges match URLs
+	// against.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Keys holds the keys xkey-scoped purges apply to.
+	Keys []string `json:"keys,omitempty"`
+
+	// Soft reports whether the purge should only expire the objects it
+	// applies to, letting them be served stale while they're refreshed.
+	Soft bool `json:"soft,omitempty"`
+
+	// Requester holds the identity of whoever requested the purge.
+	Requester string `json:"requester,omitempty"`
+
+	// Addr holds the address the purge was requested from.
+	Addr string `json:"addr,omitempty"`
+
+	// UserAgent holds the user agent the purge was requested with.
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// Reason holds the reason, if any, the requester gave for the purge.
+	Reason string `json:"reason,omitempty"`
+
+	// CreatedAt holds the time the purge was requested at, if known.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+
+	// Trace holds the W3C trace context (traceparent) the purge was requested
+	// under, if any.
+	Trace string `json:"trace,omitempty"`
+
+	// Options holds any further options of the purge.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// args returns the stream entry fields of the PurgeRequest. The URL always
+// comes first, as that's where older consumers look for it.
+func (pr *PurgeRequest) args() redis.Args {
+	args := redis.Args{}.Add("url", pr.URL)
+
+	var soft, createdAt, options string
+	if pr.Soft {
+		soft = "1"
+	}
+	if pr.CreatedAt != nil {
+		createdAt = pr.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if len(pr.Options) > 0 {
+		b, _ := json.Marshal(pr.Options)
+		options = string(b)
+	}
+
+	for _, f := range [...]struct{ key, val string }{
+		{"v", strconv.Itoa(EnvelopeVersion)},
+		{"kind", kindOf(pr.Scope)},
+		{"scope", pr.Scope},
+		{"pattern", pr.Pattern},
+		{"keys", strings.Join(pr.Keys, " ")},
+		{"soft", soft},
+		{"requester", pr.Requester},
+		{"addr", pr.Addr},
+		{"user_agent", pr.UserAgent},
+		{"reason", pr.Reason},
+		{"created_at", createdAt},
+		{"trace", pr.Trace},
+		{"options", options},
+	} {
+		if f.val != "" {
+			args = args.Add(f.key, f.val)
+		}
+	}
+
+	return args
+}
+
+// parsePurgeRequest returns the PurgeRequest the given stream entry fields
+// describe. Optional fields are decoded leniently, while entries which carry no
+// url, a version that is not an integer, or none of the fields their scope
+// requires, are reported as malformed.
+func parsePurgeRequest(fields map[string]string) (pr PurgeRequest, err error) {
+	pr = PurgeRequest{
+		URL:       fields["url"],
+		Kind:      fields["kind"],
+		Scope:     fields["scope"],
+		Pattern:   fields["pattern"],
+		Soft:      fields["soft"] == "1",
+		Requester: fields["requester"],
+		Addr:      fields["addr"],
+		UserAgent: fields["user_agent"],
+		Reason:    fields["reason"],
+		Trace:     fields["trace"],
+	}
+
+	if pr.URL == "" {
+		return pr, ErrMissingURL
+	}
+
+	if v := fields["v"]; v != "" {
+		if pr.Version, err = strconv.Atoi(v); err != nil {
+			return pr, ErrInvalidVersion
+		}
+	}
+
+	if v := fields["keys"]; v != "" {
+		pr.Keys = strings.Fields(v)
+	}
+
+	if pr.Scope == "" {
+		pr.Scope = scopeOf(pr.Kind)
+	}
+	if pr.Kind == "" {
+		pr.Kind = kindOf(pr.Scope)
+	}
+
+	switch {
+	case pr.Scope == ScopeRegex && pr.Pattern == "":
+		return pr, ErrMissingPattern
+	case pr.Scope == ScopeXKey && len(pr.Keys) == 0:
+		return pr, ErrMissingKeys
+	}
+
+	if v := fields["created_at"]; v != "" {
+		if at, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			pr.CreatedAt = &at
+		}
+	}
+
+	if v := fields["options"]; v != "" {
+		_ = json.Unmarshal([]byte(v), &pr.Options)
+	}
+
+	return pr, nil
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	createdAt := time.Date(2026, 10, 19, 14, 0, 0, 123, time.UTC)
+
+	pr := PurgeRequest{
+		URL:       "http://example.com/a",
+		Scope:     ScopeXKey,
+		Keys:      []string{"a", "b"},
+		Soft:      true,
+		Requester: "key:abc",
+		Reason:    "deploy",
+		CreatedAt: &createdAt,
+		Trace:     "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		Options:   map[string]string{"priority": "high"},
+	}
+
+	args := pr.args()
+	require.Equal(t, "url", args[0], "the url should come first")
+
+	fields := map[string]string{}
+	for i := 0; i < len(args); i += 2 {
+		fields[args[i].(string)] = fmt.Sprint(args[i+1])
+	}
+	assert.Equal(t, "1", fields["v"])
+	assert.Equal(t, KindTags, fields["kind"])
+
+	exp := pr
+	exp.Version = EnvelopeVersion
+	exp.Kind = KindTags
+	got, err := parsePurgeRequest(fields)
+	require.NoError(t, err)
+	assert.Equal(t, exp, got)
+}
+
+func TestParsePurgeRequest(t *testing.T) {
+	cases := []struct {
+		fields map[string]string
+		exp    PurgeRequest
+		err    error
+	}{
+		0: { // entries of older producers
+			fields: map[string]string{"url": "http://example.com/"},
+			exp:    PurgeRequest{URL: "http://example.com/", Kind: KindHost, Scope: ScopeHost},
+		},
+		1: { // unknown fields are ignored
+			fields: map[string]string{"url": "http://example.com/", "v": "7", "scope": ScopeExact, "foo": "bar"},
+			exp:    PurgeRequest{URL: "http://example.com/", Version: 7, Kind: KindURL, Scope: ScopeExact},
+		},
+		2: { // kinds imply scopes
+			fields: map[string]string{"url": "http://example.com/", "v": "1", "kind": KindTags, "keys": "a"},
+			exp:    PurgeRequest{URL: "http://example.com/", Version: 1, Kind: KindTags, Scope: ScopeXKey, Keys: []string{"a"}},
+		},
+		3: { // malformed optional fields are ignored
+			fields: map[string]string{"url": "http://example.com/", "created_at": "yesterday", "options": "{"},
+			exp:    PurgeRequest{URL: "http://example.com/", Kind: KindHost, Scope: ScopeHost},
+		},
+		4: { // entries which carry no url are malformed
+			fields: map[string]string{"foo": "bar"},
+			err:    ErrMissingURL,
+		},
+		5: {
+			fields: map[string]string{"url": "http://example.com/", "v": "one"},
+			err:    ErrInvalidVersion,
+		},
+		6: {
+			fields: map[string]string{"url": "http://example.com/", "v": "1", "scope": ScopeRegex},
+			err:    ErrMissingPattern,
+		},
+		7: {
+			fields: map[string]string{"url": "http://example.com/", "v": "1", "kind": KindTags, "keys": " "},
+			err:    ErrMissingKeys,
+		},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			got, err := parsePurgeRequest(kase.fields)
+			assert.Equal(t, kase.err, err)
+			if err == nil {
+				assert.Equal(t, kase.exp, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/log"
+)
+
+const results = keyspace + "results"
+
+// The set of event types.
+const (
+	// EventEnqueued denotes purge requests which have been enqueued.
+	EventEnqueued = "enqueued"
+
+	// EventApplied denotes purge requests an instance has applied.
+	EventApplied = "applied"
+
+	// EventFailed denotes purge requests an instance failed applying.
+	EventFailed = "failed"
+
+	// EventUnverified denotes purge requests an instance applied but failed
+	// verifying.
+	EventUnverified = "unverified"
+
+	// EventDropped denotes purge requests an instance dropped.
+	EventDropped = "dropped"
+
+	// EventCollapsed denotes purge requests an instance covered by collapsing
+	// its backlog.
+	EventCollapsed = "collapsed"
+
+	// EventQuarantined denotes malformed purge stream entries an instance moved
+	// to the quarantine stream.
+	EventQuarantined = "quarantined"
+)
+
+// Event wraps the details of an event concerning a purge request.
+type Event struct {
+	// Type holds the type of the Event.
+	Type string `json:"type"`
+
+	// Entry holds the ID of the purge stream entry the Event concerns.
+	Entry string `json:"entry"`
+
+	// URL holds the URL of the purge request.
+	URL string `json:"url"`
+
+	// Instance holds the ID of the target (see Cache.Target) of the instance
+	// which produced the Event, if any.
+	Instance string `json:"instance,omitempty"`
+
+	// Process holds the ID (PURGERY_ID) of the process which produced the
+	// Event, if any.
+	Process string `json:"process,omitempty"`
+
+	// Error holds the reason a purge request failed, if any.
+	Error string `json:"error,omitempty"`
+
+	// Time holds the time the Event was recorded at.
+	Time time.Time `json:"time"`
+
+	// Cursor holds the position of the Event in the event feed.
+	Cursor EventCursor `json:"-"`
+
+	id string
+}
+
+// EventCursor denotes a position in the event feed, which consists of the
+// purge and results streams.
+type EventCursor struct {
+	Purges  string
+	Results string
+}
+
+// String implements fmt.Stringer for EventCursor.
+func (ec EventCursor) String() string {
+	return ec.Purges + "," + ec.Results
+}
+
+// ParseEventCursor parses the given textual representation of an EventCursor.
+func ParseEventCursor(s string) (ec EventCursor, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 || !IsStreamID(parts[0]) || !IsStreamID(parts[1]) {
+		err = fmt.Errorf("cache: invalid event cursor (%q)", s)
+
+		return
+	}
+
+	ec.Purges, ec.Results = parts[0], parts[1]
+
+	return
+}
+
+// IsStreamID reports whether the given value is a valid stream entry ID.
+func IsStreamID(s string) bool {
+	_, _, ok := parseStreamID(s)
+
+	return ok
+}
+
+func parseStreamID(s string) (ms, seq uint64, ok bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return
+	}
+
+	var err error
+	if ms, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+		return
+	}
+	if seq, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+		return
+	}
+
+	return ms, seq, true
+}
+
+func lessStreamID(a, b string) bool {
+	ams, aseq, _ := parseStreamID(a)
+	bms, bseq, _ := parseStreamID(b)
+
+	return ams < bms || (ams == bms && aseq < bseq)
+}
+
+// Result wraps the outcome of the Cache's attempt to apply the purge request of
+// a stream entry.
+type Result struct {
+	Entry string
+	URL   string
+	Type  string
+	Err   error
+}
+
+// RecordResult records, in the results stream, the outcome of the Cache's
+// attempt to apply the purge request of the given stream entry.
+func (c *Cache) RecordResult(logger *zap.Logger, entry, url, typ string, cause error) {
+	c.RecordResults(logger, []Result{{Entry: entry, URL: url, Type: typ, Err: cause}})
+}
+
+// RecordResults records the given results in the results stream, in a single
+// round trip.
+func (c *Cache) RecordResults(logger *zap.Logger, rs []Result) {
+	if len(rs) == 0 {
+		return
+	}
+
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	for _, r := range rs {
+		args := redis.Args{}.Add(results, "MAXLEN", "~", 10000, "*").
+			Add("instance", c.Target()).
+			Add("process", c.purgeryID).
+			Add("entry", r.Entry).
+			Add("url", r.URL).
+			Add("type", r.Type)
+		if r.Err != nil {
+			args = args.Add("error", r.Err.Error())
+		}
+
+		if err := conn.Send("XADD", args...); err != nil {
+			logger.Warn("failed recording results.",
+				zap.Int("count", len(rs)),
+				zap.Error(err))
+
+			return
+		}
+	}
+
+	if _, err := conn.Do(""); err != nil {
+		logger.Warn("failed recording results.",
+			zap.Int("count", len(rs)),
+			log.Checkpoint(rs[len(rs)-1].Entry),
+			zap.Error(err))
+	}
+}
+
+// LatestEventCursor returns the cursor which points to the end of the event
+// feed.
+func (c *Cache) LatestEventCursor(logger *zap.Logger) (ec EventCursor, ok bool) {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	if ec.Purges, ok = lastID(logger, conn, stream); !ok {
+		return
+	}
+	ec.Results, ok = lastID(logger, conn, results)
+
+	return
+}
+
+func lastID(logger *zap.Logger, conn redis.Conn, key string) (id string, ok bool) {
+	vals, err := redis.Values(conn.Do("XREVRANGE", key, "+", "-", "COUNT", 1))
+	if err != nil {
+		logger.Warn("failed reading last stream id.",
+			zap.String("stream", key),
+			zap.Error(err))
+
+		return
+	}
+
+	if len(vals) == 0 {
+		return "0-0", true
+	}
+
+	// the ID of entries whose fields are malformed is still decoded
+	if e, _ := decodeEntry(vals[0]); e.id != "" {
+		return e.id, true
+	}
+
+	logger.Warn("read malformed stream entry.",
+		zap.String("stream", key))
+
+	return
+}
+
+// Events blocks for up to a second waiting for the events which follow the
+// given cursor, and returns them in order.
+func (c *Cache) Events(logger *zap.Logger, after EventCursor) (events []Event, ok bool) {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	ret, err := redis.Values(conn.Do("XREAD",
+		"COUNT", 100,
+		"BLOCK", 1000,
+		"STREAMS", stream, results,
+		after.Purges, after.Results,
+	))
+
+	switch err {
+	default:
+		logger.Warn("failed xreading events.",
+			zap.Error(err))
+
+		return nil, false
+	case redis.ErrNil:
+		return nil, true
+	case nil:
+		break
+	}
+
+	streams, err := decodeXRead(ret)
+	if err != nil {
+		logger.Warn("failed decoding events.",
+			zap.Error(err))
+
+		return nil, false
+	}
+
+	for _, s := range streams {
+		for _, v := range s.entries {
+			e, err := decodeEntry(v)
+			if err != nil {
+				logger.Debug("skipped malformed stream entry.",
+					zap.String("stream", s.key),
+					zap.Error(err))
+
+				continue
+			}
+
+			events = append(events, newEvent(s.key, e.id, e.fieldMap()))
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return lessStreamID(events[i].id, events[j].id)
+	})
+
+	cursor := after
+	for i := range events {
+		if events[i].Type == EventEnqueued {
+			cursor.Purges = events[i].id
+		} else {
+			cursor.Results = events[i].id
+		}
+		events[i].Cursor = cursor
+	}
+
+	return events, true
+}
+
+func newEvent(key, id string, fields map[string]string) Event {
+	var at time.Time
+	if ms, _, ok := parseStreamID(id); ok {
+		at = time.UnixMilli(int64(ms)).UTC()
+	}
+
+	if key == stream {
+		return Event{
+			Type:  EventEnqueued,
+			Entry: id,
+			URL:   fields["url"],
+			Time:  at,
+			id:    id,
+		}
+	}
+
+	return Event{
+		Type:     fields["type"],
+		Entry:    fields["entry"],
+		URL:      fields["url"],
+		Instance: fields["instance"],
+		Process:  fields["process"],
+		Error:    fields["error"],
+		Time:     at,
+		id:       id,
+	}
+}
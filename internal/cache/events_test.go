@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEventCursor(t *testing.T) {
+	cases := []struct {
+		in  string
+		exp EventCursor
+		err bool
+	}{
+		0: {in: "", err: true},
+		1: {in: "1-0", err: true},
+		2: {in: "1-0,2", err: true},
+		3: {in: "a-0,1-0", err: true},
+		4: {in: "1-0,2-3,4-5", err: true},
+		5: {in: "1-0,2-3", exp: EventCursor{"1-0", "2-3"}},
+		6: {in: "0-0,0-0", exp: EventCursor{"0-0", "0-0"}},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			got, err := ParseEventCursor(kase.in)
+			if kase.err {
+				assert.Error(t, err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, kase.exp, got)
+			assert.Equal(t, kase.in, got.String())
+		})
+	}
+}
+
+func TestLessStreamID(t *testing.T) {
+	cases := []struct {
+		a, b string
+		exp  bool
+	}{
+		0: {"1-0", "1-0", false},
+		1: {"1-0", "1-1", true},
+		2: {"1-1", "1-0", false},
+		3: {"9-0", "10-0", true},
+		4: {"10-0", "9-5", false},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			assert.Equal(t, kase.exp, lessStreamID(kase.a, kase.b))
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+)
+
+// Entry wraps a purge request along with the details of the purge stream entry
+// that carries it.
+type Entry struct {
+	// ID holds the ID of the stream entry.
+	ID string `json:"id"`
+
+	// Time holds the time the entry was added to the stream.
+	Time time.Time `json:"time"`
+
+	PurgeRequest
+}
+
+// Query wraps the set of filters purge stream entries may be queried by.
+type Query struct {
+	// Host, when set, limits the query to purge requests for URLs of the host.
+	Host string
+
+	// Requester, when set, limits the query to purge requests of the
+	// requester.
+	Requester string
+
+	// Scope, when set, limits the query to purges of the scope.
+	Scope string
+
+	// Since, when set, limits the query to entries added at or after it.
+	Since time.Time
+
+	// Until, when set, limits the query to entries added at or before it.
+	Until time.Time
+
+	// Limit denotes the maximum number of entries to return.
+	Limit int
+
+	// Cursor, when set, denotes the ID of the entry the query resumes after.
+	Cursor string
+
+	// Ascending denotes whether the query returns the oldest entries first,
+	// instead of the newest ones.
+	Ascending bool
+}
+
+// matches reports whether the given Entry satisfies the Query.
+func (q *Query) matches(e *Entry) bool {
+	if q.Requester != "" && q.Requester != e.Requester {
+		return false
+	}
+
+	if q.Scope != "" && q.Scope != e.Scope {
+		return false
+	}
+
+	if q.Host != "" && !strings.EqualFold(q.Host, hostOf(e.URL)) {
+		return false
+	}
+
+	return true
+}
+
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
+
+// maxScanned denotes the maximum number of entries a single query examines.
+const maxScanned = 10000
+
+// Page wraps a page of query results.
+type Page struct {
+	// Entries holds the entries of the Page.
+	Entries []Entry `json:"entries"`
+
+	// Next holds the cursor of the following Page, or an empty string when
+	// there is no following Page.
+	Next string `json:"next,omitempty"`
+}
+
+// Entries returns the page of purge stream entries which satisfy the given
+// Query.
+//
+// Queries which examine maxScanned entries without filling their page return
+// early, along with the cursor of the next page.
+func (c *Cache) Entries(logger *zap.Logger, q *Query) (page Page, ok bool) {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	const batch = 500
+
+	cmd, low, high := "XREVRANGE", "-", "+"
+	if q.Ascending {
+		cmd = "XRANGE"
+	}
+	if !q.Since.IsZero() {
+		low = strconv.FormatInt(q.Since.UnixMilli(), 10)
+	}
+	if !q.Until.IsZero() {
+		high = strconv.FormatInt(q.Until.UnixMilli(), 10)
+	}
+
+	// from denotes where each read begins; it's the upper end of the range
+	// when descending and the lower one when ascending.
+	from, to := &high, low
+	if q.Ascending {
+		from, to = &low, high
+	}
+	if q.Cursor != "" {
+		*from = "(" + q.Cursor
+	}
+
+	page.Entries = []Entry{}
+	for scanned := 0; scanned < maxScanned; {
+		vals, err := redis.Values(conn.Do(cmd, stream, *from, to, "COUNT", batch))
+		if err != nil {
+			logger.Error("failed querying purge stream.",
+				zap.Error(err))
+
+			return page, false
+		}
+
+		for _, v := range vals {
+			scanned++
+
+			e, ok := parseEntry(v)
+			if e.ID != "" {
+				*from = "(" + e.ID
+				page.Next = e.ID
+			}
+
+			if !ok || !q.matches(&e) {
+				continue
+			}
+
+			if page.Entries = append(page.Entries, e); len(page.Entries) == q.Limit {
+				return page, true
+			}
+		}
+
+		if len(vals) < batch {
+			page.Next = "" // exhausted
+
+			return page, true
+		}
+	}
+
+	logger.Warn("purge stream query scan limit reached.",
+		zap.Int("limit", maxScanned))
+
+	return page, true
+}
+
+// parseEntry parses the given stream entry. The ID of the returned Entry is set
+// even when the entry's fields are malformed.
+func parseEntry(v interface{}) (e Entry, ok bool) {
+	e, err := decodePurgeEntry(v)
+
+	return e, err == nil
+}
@@ -0,0 +1,286 @@
+package cache
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// historyConn implements a redis.Conn which serves XRANGE and XREVRANGE
+// queries of a purge stream, honouring exclusive and incomplete IDs.
+type historyConn struct {
+	entries []interface{} // in their wire format, in order
+}
+
+// add adds an entry of the given URL and fields to the stream.
+func (c *historyConn) add(id, url string, fields ...string) {
+	c.entries = append(c.entries, entry(id, append([]string{"url", url, "v", "1"}, fields...)...))
+}
+
+func (c *historyConn) newCache() *Cache {
+	return New("a", &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return c, nil
+		},
+	})
+}
+
+func (c *historyConn) Close() error { return nil }
+func (c *historyConn) Err() error   { return nil }
+
+func (c *historyConn) Send(string, ...interface{}) error { return errors.New("not implemented") }
+func (c *historyConn) Flush() error                      { return errors.New("not implemented") }
+func (c *historyConn) Receive() (interface{}, error)     { return nil, errors.New("not implemented") }
+
+func (c *historyConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	var low, high string
+
+	switch cmd {
+	case "":
+		return nil, nil // connection pools flush via empty commands
+	case "XRANGE":
+		// stream, start, end, COUNT, count
+		low, high = args[1].(string), args[2].(string)
+	case "XREVRANGE":
+		// stream, end, start, COUNT, count
+		low, high = args[2].(string), args[1].(string)
+	default:
+		return nil, errors.New("unexpected command: " + cmd)
+	}
+
+	count := args[4].(int)
+
+	in := func(v interface{}) bool {
+		id := string(v.([]interface{})[0].([]byte))
+
+		return !below(id, low) && !above(id, high)
+	}
+
+	var reply []interface{}
+	for i := range c.entries {
+		if cmd == "XREVRANGE" {
+			i = len(c.entries) - 1 - i
+		}
+
+		if len(reply) < count && in(c.entries[i]) {
+			reply = append(reply, c.entries[i])
+		}
+	}
+
+	return reply, nil
+}
+
+// parseBound parses the given range bound, which may be exclusive (prefixed by
+// a parenthesis) or incomplete (in which case its sequence is the lowest or the
+// highest one).
+func parseBound(bound string, upper bool) (ms, seq uint64, exclusive bool) {
+	switch bound {
+	case "-":
+		return 0, 0, false
+	case "+":
+		return math.MaxUint64, math.MaxUint64, false
+	}
+
+	if exclusive = strings.HasPrefix(bound, "("); exclusive {
+		bound = bound[1:]
+	}
+
+	if strings.Contains(bound, "-") {
+		ms, seq, _ = parseStreamID(bound)
+
+		return
+	}
+
+	if ms, _ = strconv.ParseUint(bound, 10, 64); upper {
+		seq = math.MaxUint64
+	}
+
+	return
+}
+
+// below reports whether the given ID precedes the given lower bound.
+func below(id, bound string) bool {
+	ms, seq, exclusive := parseBound(bound, false)
+	b := formatStreamID(ms, seq)
+
+	return lessStreamID(id, b) || (exclusive && id == b)
+}
+
+// above reports whether the given ID follows the given upper bound.
+func above(id, bound string) bool {
+	ms, seq, exclusive := parseBound(bound, true)
+	b := formatStreamID(ms, seq)
+
+	return lessStreamID(b, id) || (exclusive && id == b)
+}
+
+func entryIDs(entries []Entry) (ids []string) {
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+
+	return
+}
+
+func TestEntries(t *testing.T) {
+	conn := new(historyConn)
+	conn.add("1000-0", "http://a.com/1", "scope", ScopeExact)
+	conn.add("1000-1", "http://B.com:8080/1", "scope", ScopeExact, "requester", "key:a")
+	conn.entries = append(conn.entries, entry("1500-0", "v", "1")) // malformed
+	conn.add("2000-0", "http://a.com/2", "scope", ScopeHost)
+	conn.add("2000-1", "http://b.com/2", "scope", ScopePrefix)
+	conn.add("3000-0", "http://a.com/3", "scope", ScopeExact, "requester", "key:a")
+	c := conn.newCache()
+
+	at := func(ms int64) time.Time {
+		return time.UnixMilli(ms)
+	}
+
+	cases := []struct {
+		q    Query
+		ids  []string
+		next string
+	}{
+		0: { // newest first
+			q:    Query{Limit: 2},
+			ids:  []string{"3000-0", "2000-1"},
+			next: "2000-1",
+		},
+		1: { // cursors are exclusive
+			q:    Query{Limit: 2, Cursor: "2000-1"},
+			ids:  []string{"2000-0", "1000-1"},
+			next: "1000-1",
+		},
+		2: { // pages which exhaust the stream have no following one
+			q:   Query{Limit: 2, Cursor: "1000-1"},
+			ids: []string{"1000-0"},
+		},
+		3: { // oldest first
+			q:    Query{Limit: 2, Ascending: true},
+			ids:  []string{"1000-0", "1000-1"},
+			next: "1000-1",
+		},
+		4: {
+			q:    Query{Limit: 2, Ascending: true, Cursor: "1000-1"},
+			ids:  []string{"2000-0", "2000-1"},
+			next: "2000-1",
+		},
+		5: {
+			q:   Query{Limit: 2, Ascending: true, Cursor: "2000-1"},
+			ids: []string{"3000-0"},
+		},
+		6: { // hosts match case-insensitively, regardless of the port
+			q:   Query{Limit: 10, Host: "b.COM"},
+			ids: []string{"2000-1", "1000-1"},
+		},
+		7: { // cursors which land on entries the query filters out
+			q:   Query{Limit: 10, Host: "b.com", Cursor: "2000-0"},
+			ids: []string{"1000-1"},
+		},
+		8: {
+			q:   Query{Limit: 10, Host: "a.com", Ascending: true, Cursor: "2000-1"},
+			ids: []string{"3000-0"},
+		},
+		9: { // cursors which land on malformed entries
+			q:   Query{Limit: 10, Cursor: "1500-0"},
+			ids: []string{"1000-1", "1000-0"},
+		},
+		10: { // time bounds span whole milliseconds, inclusively
+			q:   Query{Limit: 10, Since: at(1000), Until: at(2000)},
+			ids: []string{"2000-1", "2000-0", "1000-1", "1000-0"},
+		},
+		11: {
+			q:   Query{Limit: 10, Since: at(1001), Until: at(2999), Ascending: true},
+			ids: []string{"2000-0", "2000-1"},
+		},
+		12: { // cursors narrow time bounds further
+			q:   Query{Limit: 10, Since: at(1000), Until: at(2000), Cursor: "2000-0"},
+			ids: []string{"1000-1", "1000-0"},
+		},
+		13: {
+			q:   Query{Limit: 10, Requester: "key:a", Scope: ScopeExact},
+			ids: []string{"3000-0", "1000-1"},
+		},
+		14: {
+			q: Query{Limit: 10, Host: "c.com"},
+		},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			page, ok := c.Entries(zap.NewNop(), &kase.q)
+			require.True(t, ok)
+			assert.Equal(t, kase.ids, entryIDs(page.Entries))
+			assert.Equal(t, kase.next, page.Next)
+		})
+	}
+}
+
+func TestEntriesScanLimit(t *testing.T) {
+	conn := new(historyConn)
+	for i := 1; i <= maxScanned+1; i++ {
+		conn.add(formatStreamID(uint64(i), 0), "http://a.com/")
+	}
+	conn.add(formatStreamID(maxScanned+2, 0), "http://b.com/")
+	c := conn.newCache()
+
+	logger := zap.NewNop()
+	q := Query{Limit: 10, Host: "b.com", Ascending: true}
+
+	// queries which scan maxScanned entries return early, with a cursor which
+	// resumes past them
+	page, ok := c.Entries(logger, &q)
+	require.True(t, ok)
+	assert.Empty(t, page.Entries)
+	assert.Equal(t, formatStreamID(maxScanned, 0), page.Next)
+
+	q.Cursor = page.Next
+	page, ok = c.Entries(logger, &q)
+	require.True(t, ok)
+	assert.Equal(t, []string{formatStreamID(maxScanned+2, 0)}, entryIDs(page.Entries))
+	assert.Empty(t, page.Next)
+}
+
+func TestQueryMatches(t *testing.T) {
+	e := Entry{
+		ID: "1-0",
+		PurgeRequest: PurgeRequest{
+			URL:       "http://Example.com:8080/a",
+			Scope:     ScopeExact,
+			Requester: "key:a",
+		},
+	}
+
+	cases := []struct {
+		q   Query
+		exp bool
+	}{
+		0: {exp: true},
+		1: {q: Query{Host: "example.com"}, exp: true},
+		2: {q: Query{Host: "EXAMPLE.COM"}, exp: true},
+		3: {q: Query{Host: "example.com:8080"}},
+		4: {q: Query{Host: "example.org"}},
+		5: {q: Query{Requester: "key:a", Scope: ScopeExact}, exp: true},
+		6: {q: Query{Requester: "key:A"}},
+		7: {q: Query{Scope: ScopeHost}},
+		8: {q: Query{Host: "example.com", Requester: "key:b"}},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			assert.Equal(t, kase.exp, kase.q.matches(&e))
+		})
+	}
+}
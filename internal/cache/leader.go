@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+)
+
+// leadershipScript acquires or extends, for ARGV[2] milliseconds, the lease
+// KEYS[1] on behalf of ARGV[1]. It returns the fencing token of the lease,
+// which KEYS[2] increments each time the lease changes hands, or 0 when
+// another process holds it.
+var leadershipScript = redis.NewScript(2, `
+	local owner = redis.call("GET", KEYS[1])
+	if owner == ARGV[1] then
+		redis.call("PEXPIRE", KEYS[1], ARGV[2])
+
+		return tonumber(redis.call("GET", KEYS[2]) or 0)
+	elseif owner then
+		return 0
+	end
+
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+
+	return redis.call("INCR", KEYS[2])
+`)
+
+// leadershipLease returns the name of the lease the processes which serve the
+// Cache's target compete for.
+func (c *Cache) leadershipLease() string {
+	return "targets:" + c.Target()
+}
+
+func fenceKey(lease string) string {
+	return leaseKey(lease) + ":fence"
+}
+
+// AcquireLeadership acquires or extends, for ttl, the lease of the Cache's
+// target and returns its fencing token. The returned token is 0 when another
+// process holds the lease, in which case it leads the processes which serve the
+// target.
+//
+// Checkpoints the Cache stores once it has held the lease are rejected after
+// the token it last acquired has been superseded.
+func (c *Cache) AcquireLeadership(logger *zap.Logger, ttl time.Duration) (token int64, ok bool) {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	lease := c.leadershipLease()
+	logger = logger.With(zap.String("lease", lease))
+
+	token, err := redis.Int64(leadershipScript.Do(conn, leaseKey(lease), fenceKey(lease),
+		c.purgeryID, ttl.Milliseconds()))
+	if err != nil {
+		logger.Warn("failed acquiring leadership.",
+			zap.Error(err))
+
+		return 0, false
+	}
+
+	// once set, the token is kept even when leadership is lost, so that the
+	// checkpoints a deposed leader still attempts to store are rejected.
+	if token > 0 {
+		atomic.StoreInt64(&c.fence, token)
+	}
+
+	return token, true
+}
+
+// ReleaseLeadership releases the lease of the Cache's target, in case the Cache
+// holds it.
+func (c *Cache) ReleaseLeadership(logger *zap.Logger) {
+	c.ReleaseLease(logger, c.leadershipLease())
+}
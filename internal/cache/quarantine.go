@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/log"
+	"github.com/soupedup/purgery/internal/metrics"
+)
+
+// quarantine is the stream malformed purge stream entries are moved to.
+const quarantine = keyspace + "quarantine"
+
+var quarantinedEntries = metrics.Counter("quarantined_entries")
+
+// quarantineScript moves the purge stream entry ARGV[1] into the quarantine
+// stream, as an entry which consists of the rest of ARGV. Entries another
+// instance has already moved are left alone.
+var quarantineScript = redis.NewScript(2, `
+	if redis.call("XDEL", KEYS[1], ARGV[1]) == 0 then
+		return 0
+	end
+
+	redis.call("XADD", KEYS[2], "MAXLEN", "~", 10000, "*", unpack(ARGV, 2))
+
+	return 1
+`)
+
+// quarantine moves the malformed purge stream entry the given error concerns
+// into the quarantine stream, along with its raw fields and the reason it's
+// malformed.
+func (c *Cache) quarantine(logger *zap.Logger, conn redis.Conn, ee *EntryError) bool {
+	logger = logger.With(log.Checkpoint(ee.ID), zap.Error(ee.Err))
+	logger.Warn("quarantining malformed entry ...")
+
+	args := redis.Args{}.Add(stream, quarantine, ee.ID).
+		Add("_entry", ee.ID).
+		Add("_instance", c.Target()).
+		Add("_process", c.purgeryID).
+		Add("_error", ee.Err.Error()).
+		AddFlat(ee.Fields)
+	if len(ee.Fields)%2 != 0 {
+		args = args.Add("") // keep the fields paired
+	}
+
+	moved, err := redis.Bool(quarantineScript.Do(conn, args...))
+	if err != nil {
+		logger.Error("failed quarantining malformed entry.",
+			zap.Error(err))
+
+		return false
+	}
+
+	if moved {
+		quarantinedEntries.Add(1)
+
+		logger.Info("quarantined malformed entry.")
+	} else {
+		logger.Debug("malformed entry already quarantined.")
+	}
+
+	return true
+}
@@ -0,0 +1,304 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/log"
+)
+
+const (
+	scheduled         = keyspace + "scheduled"
+	scheduledRequests = scheduled + ":requests"
+)
+
+// ScheduledPurge wraps the details of a purge request which has been scheduled
+// for later.
+type ScheduledPurge struct {
+	ID        string    `json:"id"`
+	NotBefore time.Time `json:"not_before"`
+	PurgeRequest
+}
+
+var scheduleScript = redis.NewScript(2, `
+	redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+	redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+
+	return ARGV[1]
+`)
+
+// SchedulePurgeRequest schedules the given purge request to be enqueued once
+// notBefore has passed.
+func (c *Cache) SchedulePurgeRequest(logger *zap.Logger, pr *PurgeRequest, notBefore time.Time) (id string, ok bool) {
+	logger = logger.With(log.URL(pr.URL), zap.Time("not_before", notBefore))
+	logger.Info("scheduling purge request ...")
+
+	if pr.CreatedAt == nil {
+		now := time.Now().UTC()
+		pr.CreatedAt = &now
+	}
+
+	var err error
+	if id, err = newScheduleID(); err != nil {
+		logger.Error("failed generating schedule id.",
+			zap.Error(err))
+
+		return
+	}
+
+	// requests are held as the fields of the entries they're enqueued as
+	fields, err := redis.Strings([]interface{}(pr.args()), nil)
+	if err != nil {
+		logger.Error("failed encoding purge request.",
+			zap.Error(err))
+
+		return "", false
+	}
+
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		logger.Error("failed encoding purge request.",
+			zap.Error(err))
+
+		return "", false
+	}
+
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	if _, err = scheduleScript.Do(conn, scheduled, scheduledRequests, id, payload, notBefore.UnixMilli()); err != nil {
+		logger.Error("failed scheduling purge request.",
+			zap.Error(err))
+
+		return
+	}
+
+	logger.Debug("scheduled purge request.", zap.String("id", id))
+
+	return id, true
+}
+
+func newScheduleID() (string, error) {
+	var buf [12]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// ScheduledPurges returns the purge requests which are pending, in the order
+// they're due.
+func (c *Cache) ScheduledPurges(logger *zap.Logger) (purges []ScheduledPurge, ok bool) {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	logger.Debug("listing scheduled purge requests ...")
+
+	pairs, err := redis.Strings(conn.Do("ZRANGE", scheduled, 0, -1, "WITHSCORES"))
+	if err != nil {
+		logger.Error("failed listing scheduled purge requests.",
+			zap.Error(err))
+
+		return
+	}
+
+	purges = make([]ScheduledPurge, 0, len(pairs)/2)
+	if len(pairs) == 0 {
+		return purges, true
+	}
+
+	args := redis.Args{}.Add(scheduledRequests)
+	for i := 0; i < len(pairs); i += 2 {
+		args = args.Add(pairs[i])
+	}
+
+	payloads, err := redis.ByteSlices(conn.Do("HMGET", args...))
+	if err != nil {
+		logger.Error("failed fetching scheduled purge requests.",
+			zap.Error(err))
+
+		return nil, false
+	}
+
+	for i, payload := range payloads {
+		if payload == nil {
+			continue // cancelled or promoted in the meantime
+		}
+
+		sp := ScheduledPurge{
+			ID: pairs[2*i],
+		}
+
+		var e rawEntry
+		err := json.Unmarshal(payload, &e.fields)
+		if err == nil {
+			sp.PurgeRequest, err = parsePurgeRequest(e.fieldMap())
+		}
+		if err != nil {
+			logger.Warn("failed decoding scheduled purge request.",
+				zap.String("id", sp.ID),
+				zap.Error(err))
+
+			continue
+		}
+
+		score, _ := strconv.ParseFloat(pairs[2*i+1], 64)
+		sp.NotBefore = time.UnixMilli(int64(score)).UTC()
+
+		purges = append(purges, sp)
+	}
+
+	return purges, true
+}
+
+var cancelScript = redis.NewScript(2, `
+	if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+		return 0
+	end
+	redis.call("HDEL", KEYS[2], ARGV[1])
+
+	return 1
+`)
+
+// CancelScheduledPurge cancels the scheduled purge request with the given ID.
+// Cancelling a request which doesn't exist (or has already been enqueued)
+// reports found as false.
+func (c *Cache) CancelScheduledPurge(logger *zap.Logger, id string) (found, ok bool) {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	logger = logger.With(zap.String("id", id))
+	logger.Info("cancelling scheduled purge request ...")
+
+	n, err := redis.Int(cancelScript.Do(conn, scheduled, scheduledRequests, id))
+	if err != nil {
+		logger.Error("failed cancelling scheduled purge request.",
+			zap.Error(err))
+
+		return
+	}
+
+	if found = n == 1; found {
+		logger.Debug("cancelled scheduled purge request.")
+	} else {
+		logger.Debug("scheduled purge request not found.")
+	}
+
+	return found, true
+}
+
+// promoteScript moves up to ARGV[1] due entries of the scheduled set into the
+// purge stream, as entries which consist of the fields their requests were
+// scheduled with. Since scripts run atomically, each entry is promoted exactly
+// once, no matter how many instances run the script concurrently.
+var promoteScript = redis.NewScript(3, `
+	local at = redis.call('TIME')
+	local now = (at[1] * 1000) + math.floor(at[2] / 1000)
+
+	local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", now, "LIMIT", 0, ARGV[1])
+	for _, id in ipairs(ids) do
+		local payload = redis.call("HGET", KEYS[2], id)
+		if payload then
+			redis.call("XADD", KEYS[3], "MINID", "~", "0-0", "*", unpack(cjson.decode(payload)))
+		end
+
+		redis.call("ZREM", KEYS[1], id)
+		redis.call("HDEL", KEYS[2], id)
+	end
+
+	return #ids
+`)
+
+// PromoteScheduledPurges enqueues up to max scheduled purge requests which
+// have come due and reports how many it enqueued.
+func (c *Cache) PromoteScheduledPurges(logger *zap.Logger, max int) (n int, ok bool) {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	logger.Debug("promoting scheduled purge requests ...")
+
+	n, err := redis.Int(promoteScript.Do(conn, scheduled, scheduledRequests, stream, max))
+	if err != nil {
+		logger.Error("failed promoting scheduled purge requests.",
+			zap.Error(err))
+
+		return
+	}
+
+	if n > 0 {
+		logger.Info("promoted scheduled purge requests.",
+			zap.Int("count", n))
+	}
+
+	return n, true
+}
+
+var leaseScript = redis.NewScript(1, `
+	local owner = redis.call("GET", KEYS[1])
+	if owner == ARGV[1] then
+		redis.call("PEXPIRE", KEYS[1], ARGV[2])
+
+		return 1
+	elseif not owner then
+		redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+
+		return 1
+	end
+
+	return 0
+`)
+
+func leaseKey(name string) string {
+	return keyspace + "leases:" + name
+}
+
+// AcquireLease reports whether the Cache holds the named lease, acquiring or
+// extending it for ttl as required.
+func (c *Cache) AcquireLease(logger *zap.Logger, name string, ttl time.Duration) bool {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	logger = logger.With(zap.String("lease", name))
+
+	held, err := redis.Bool(leaseScript.Do(conn, leaseKey(name), c.purgeryID, ttl.Milliseconds()))
+	if err != nil {
+		logger.Warn("failed acquiring lease.",
+			zap.Error(err))
+
+		return false
+	}
+
+	return held
+}
+
+var releaseScript = redis.NewScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+
+	return 0
+`)
+
+// ReleaseLease releases the named lease, in case the Cache holds it.
+func (c *Cache) ReleaseLease(logger *zap.Logger, name string) {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	logger = logger.With(zap.String("lease", name))
+
+	if _, err := releaseScript.Do(conn, leaseKey(name), c.purgeryID); err != nil {
+		logger.Warn("failed releasing lease.",
+			zap.Error(err))
+
+		return
+	}
+
+	logger.Debug("released lease.")
+}
@@ -0,0 +1,350 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// scheduleConn implements a redis.Conn which emulates the scripts, and the
+// commands, scheduled purge requests and leases are managed with.
+type scheduleConn struct {
+	now      int64             // the time, in milliseconds, promotions run at
+	scores   map[string]int64  // of the scheduled set, by ID
+	payloads map[string][]byte // of the scheduled requests, by ID
+	entries  [][]string        // fields of the entries of the purge stream
+	leases   map[string]string // owners, by key
+}
+
+func newScheduleConn() *scheduleConn {
+	return &scheduleConn{
+		scores:   make(map[string]int64),
+		payloads: make(map[string][]byte),
+		leases:   make(map[string]string),
+	}
+}
+
+func (c *scheduleConn) newCache(purgeryID string) *Cache {
+	return New(purgeryID, &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return c, nil
+		},
+	})
+}
+
+// due returns the IDs of the scheduled set, in the order they're due.
+func (c *scheduleConn) due() (ids []string) {
+	for id := range c.scores {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := ids[i], ids[j]
+
+		return c.scores[a] < c.scores[b] || (c.scores[a] == c.scores[b] && a < b)
+	})
+
+	return
+}
+
+func (c *scheduleConn) Close() error { return nil }
+func (c *scheduleConn) Err() error   { return nil }
+
+func (c *scheduleConn) Send(string, ...interface{}) error { return errors.New("not implemented") }
+func (c *scheduleConn) Flush() error                      { return errors.New("not implemented") }
+func (c *scheduleConn) Receive() (interface{}, error)     { return nil, errors.New("not implemented") }
+
+func (c *scheduleConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "":
+		return nil, nil // connection pools flush via empty commands
+	case "EVALSHA":
+		return c.eval(args[0].(string), args[2:])
+	case "ZRANGE":
+		// scheduled, 0, -1, WITHSCORES
+		var reply []interface{}
+		for _, id := range c.due() {
+			reply = append(reply, []byte(id), []byte(strconv.FormatInt(c.scores[id], 10)))
+		}
+
+		return reply, nil
+	case "HMGET":
+		// requests, ids...
+		reply := make([]interface{}, 0, len(args)-1)
+		for _, id := range args[1:] {
+			if payload, ok := c.payloads[id.(string)]; ok {
+				reply = append(reply, payload)
+			} else {
+				reply = append(reply, nil)
+			}
+		}
+
+		return reply, nil
+	}
+
+	return nil, errors.New("unexpected command: " + cmd)
+}
+
+func (c *scheduleConn) eval(hash string, args []interface{}) (interface{}, error) {
+	switch hash {
+	case scheduleScript.Hash(), cancelScript.Hash(), promoteScript.Hash():
+		if args[0] != scheduled || args[1] != scheduledRequests {
+			return nil, errors.New("unexpected keys")
+		}
+	}
+
+	switch hash {
+	case scheduleScript.Hash():
+		// scheduled, requests, id, payload, score
+		id := args[2].(string)
+		c.scores[id], c.payloads[id] = args[4].(int64), args[3].([]byte)
+
+		return []byte(id), nil
+	case cancelScript.Hash():
+		// scheduled, requests, id
+		id := args[2].(string)
+		if _, ok := c.scores[id]; !ok {
+			return int64(0), nil
+		}
+		delete(c.scores, id)
+		delete(c.payloads, id)
+
+		return int64(1), nil
+	case promoteScript.Hash():
+		// scheduled, requests, stream, max
+		if args[2] != stream {
+			return nil, errors.New("unexpected stream")
+		}
+
+		var n int64
+		for _, id := range c.due() {
+			if c.scores[id] > c.now || n == int64(args[3].(int)) {
+				break
+			}
+
+			var fields []string
+			if err := json.Unmarshal(c.payloads[id], &fields); err != nil {
+				return nil, err
+			}
+			c.entries = append(c.entries, fields)
+
+			delete(c.scores, id)
+			delete(c.payloads, id)
+			n++
+		}
+
+		return n, nil
+	case leaseScript.Hash():
+		// lease, owner, ttl
+		key, owner := args[0].(string), args[1].(string)
+		if held := c.leases[key]; held != "" && held != owner {
+			return int64(0), nil
+		}
+		c.leases[key] = owner
+
+		return int64(1), nil
+	case releaseScript.Hash():
+		// lease, owner
+		key := args[0].(string)
+		if c.leases[key] != args[1].(string) {
+			return int64(0), nil
+		}
+		delete(c.leases, key)
+
+		return int64(1), nil
+	}
+
+	return nil, errors.New("unexpected script: " + hash)
+}
+
+func TestScheduledPurges(t *testing.T) {
+	conn := newScheduleConn()
+	c := conn.newCache("a")
+	logger := zap.NewNop()
+
+	at := time.Date(2026, 10, 19, 14, 0, 0, 0, time.UTC)
+
+	prs := []PurgeRequest{
+		0: {URL: "http://example.com/a", Scope: ScopeExact, Reason: "a"},
+		1: {URL: "http://example.com/b", Scope: ScopeXKey, Keys: []string{"k1", "k2"}},
+		2: {URL: "http://example.com/c", Scope: ScopeRegex, Pattern: "^/c", Soft: true},
+	}
+	notBefore := []time.Time{
+		0: at.Add(time.Hour),
+		1: at.Add(time.Minute),
+		2: at.Add(time.Second),
+	}
+
+	ids := make([]string, len(prs))
+	for i := range prs {
+		var ok bool
+		ids[i], ok = c.SchedulePurgeRequest(logger, &prs[i], notBefore[i])
+		require.True(t, ok)
+		require.NotEmpty(t, ids[i])
+	}
+
+	purges, ok := c.ScheduledPurges(logger)
+	require.True(t, ok)
+	require.Len(t, purges, 3)
+
+	// purges are listed in the order they're due
+	for i, j := range []int{2, 1, 0} {
+		exp := prs[j]
+		exp.Version = EnvelopeVersion
+		exp.Kind = kindOf(exp.Scope)
+		exp.CreatedAt = purges[i].CreatedAt // as set when scheduled
+
+		assert.Equal(t, ids[j], purges[i].ID)
+		assert.Equal(t, notBefore[j], purges[i].NotBefore)
+		assert.Equal(t, exp, purges[i].PurgeRequest)
+		assert.NotNil(t, purges[i].CreatedAt)
+	}
+
+	// requests which were cancelled, or promoted, in the meantime are skipped
+	delete(conn.payloads, ids[1])
+
+	purges, ok = c.ScheduledPurges(logger)
+	require.True(t, ok)
+	require.Len(t, purges, 2)
+	assert.Equal(t, ids[2], purges[0].ID)
+	assert.Equal(t, ids[0], purges[1].ID)
+}
+
+func TestCancelScheduledPurge(t *testing.T) {
+	cases := []struct {
+		promote bool   // whether the request is promoted before it's cancelled
+		id      string // the ID to cancel, when not the one scheduled
+		found   bool
+	}{
+		0: {found: true},
+		1: {id: "unknown"},
+		2: {promote: true},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			conn := newScheduleConn()
+			c := conn.newCache("a")
+			logger := zap.NewNop()
+
+			at := time.Now()
+
+			id, ok := c.SchedulePurgeRequest(logger, &PurgeRequest{URL: "http://example.com/"}, at)
+			require.True(t, ok)
+
+			if kase.promote {
+				conn.now = at.UnixMilli()
+
+				n, ok := c.PromoteScheduledPurges(logger, 10)
+				require.True(t, ok)
+				require.Equal(t, 1, n)
+			}
+
+			if kase.id != "" {
+				id = kase.id
+			}
+
+			found, ok := c.CancelScheduledPurge(logger, id)
+			require.True(t, ok)
+			assert.Equal(t, kase.found, found)
+
+			// a request is either cancelled, promoted or still pending
+			purges, ok := c.ScheduledPurges(logger)
+			require.True(t, ok)
+			assert.Equal(t, !kase.found && !kase.promote, len(purges) == 1)
+			if kase.promote {
+				assert.Len(t, conn.entries, 1)
+			} else {
+				assert.Empty(t, conn.entries)
+			}
+		})
+	}
+}
+
+func TestPromoteScheduledPurges(t *testing.T) {
+	conn := newScheduleConn()
+	c := conn.newCache("a")
+	logger := zap.NewNop()
+
+	at := time.Date(2026, 10, 19, 14, 0, 0, 0, time.UTC)
+	createdAt := at.Add(-time.Hour)
+
+	prs := []PurgeRequest{
+		0: {URL: "http://example.com/a", Scope: ScopeXKey, Keys: []string{"k"}, Soft: true, CreatedAt: &createdAt},
+		1: {URL: "http://example.com/b", Scope: ScopeExact, Options: map[string]string{"o": "1"}, CreatedAt: &createdAt},
+		2: {URL: "http://example.com/c", Scope: ScopeHost, CreatedAt: &createdAt},
+		3: {URL: "http://example.com/d", Scope: ScopeHost, CreatedAt: &createdAt}, // not due yet
+	}
+	notBefore := []time.Time{
+		0: at.Add(-time.Second),
+		1: at.Add(-time.Minute),
+		2: at,
+		3: at.Add(time.Millisecond),
+	}
+
+	for i := range prs {
+		_, ok := c.SchedulePurgeRequest(logger, &prs[i], notBefore[i])
+		require.True(t, ok)
+	}
+	conn.now = at.UnixMilli()
+
+	// promotions are bounded, and start with the requests due first
+	n, ok := c.PromoteScheduledPurges(logger, 2)
+	require.True(t, ok)
+	assert.Equal(t, 2, n)
+
+	n, ok = c.PromoteScheduledPurges(logger, 2)
+	require.True(t, ok)
+	assert.Equal(t, 1, n)
+
+	n, ok = c.PromoteScheduledPurges(logger, 2)
+	require.True(t, ok)
+	assert.Equal(t, 0, n)
+
+	// requests are enqueued verbatim, as the entries they'd be enqueued as
+	// right away
+	require.Len(t, conn.entries, 3)
+	for i, j := range []int{1, 0, 2} {
+		exp, err := redis.Strings([]interface{}(prs[j].args()), nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, exp, conn.entries[i])
+	}
+
+	purges, ok := c.ScheduledPurges(logger)
+	require.True(t, ok)
+	require.Len(t, purges, 1)
+	assert.Equal(t, prs[3].URL, purges[0].URL)
+}
+
+func TestLease(t *testing.T) {
+	conn := newScheduleConn()
+	a, b := conn.newCache("a"), conn.newCache("b")
+	logger := zap.NewNop()
+
+	// leases are held by a single process at a time
+	assert.True(t, a.AcquireLease(logger, "test", time.Second))
+	assert.False(t, b.AcquireLease(logger, "test", time.Second))
+	assert.True(t, a.AcquireLease(logger, "test", time.Second))
+
+	// leases are only released by the process which holds them
+	b.ReleaseLease(logger, "test")
+	assert.False(t, b.AcquireLease(logger, "test", time.Second))
+
+	a.ReleaseLease(logger, "test")
+	assert.True(t, b.AcquireLease(logger, "test", time.Second))
+	assert.False(t, a.AcquireLease(logger, "test", time.Second))
+
+	// leases are independent of one another
+	assert.True(t, a.AcquireLease(logger, "other", time.Second))
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// The set of start position modes.
+const (
+	// StartNow denotes instances which start consuming the purge stream from
+	// its end. It's the default.
+	StartNow = "now"
+
+	// StartEarliest denotes instances which start consuming the purge stream
+	// from its first entry.
+	StartEarliest = "earliest"
+
+	startAgo = "ago"
+	startID  = "id"
+)
+
+// StartPosition denotes where instances which have no checkpoint start
+// consuming the purge stream from.
+type StartPosition struct {
+	mode string
+	ago  time.Duration
+	id   string // the entry preceding the one to start from
+	text string
+}
+
+// ErrInvalidStartPosition is returned by ParseStartPosition for start
+// positions which are neither now, earliest, a positive duration nor a stream
+// entry ID.
+var ErrInvalidStartPosition = errors.New("cache: invalid start position")
+
+// ParseStartPosition parses the given start position, which is either now (the
+// default), earliest, a duration (i.e. 15m) which denotes a point in the past
+// or the ID of the stream entry to start from.
+func ParseStartPosition(v string) (sp StartPosition, err error) {
+	sp.text = v
+
+	switch v {
+	case "", StartNow:
+		sp.mode, sp.text = StartNow, StartNow
+	case StartEarliest:
+		sp.mode = StartEarliest
+	default:
+		if sp.ago, err = time.ParseDuration(v); err == nil {
+			if sp.ago <= 0 {
+				return sp, ErrInvalidStartPosition
+			}
+			sp.mode = startAgo
+
+			break
+		}
+
+		var ok bool
+		if sp.id, ok = precedingStreamID(v); !ok {
+			return sp, ErrInvalidStartPosition
+		}
+		sp.mode, err = startID, nil
+	}
+
+	return sp, nil
+}
+
+// String implements fmt.Stringer for StartPosition.
+func (sp StartPosition) String() string {
+	if sp.mode == "" {
+		return StartNow
+	}
+
+	return sp.text
+}
+
+// scriptArgs returns the checkpointScript arguments of the StartPosition.
+func (sp StartPosition) scriptArgs() []interface{} {
+	switch sp.mode {
+	case StartEarliest:
+		return []interface{}{startID, "0-0"}
+	case startAgo:
+		return []interface{}{startAgo, strconv.FormatInt(sp.ago.Milliseconds(), 10)}
+	case startID:
+		return []interface{}{startID, sp.id}
+	default:
+		return []interface{}{StartNow, ""}
+	}
+}
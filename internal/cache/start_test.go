@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStartPosition(t *testing.T) {
+	cases := []struct {
+		in   string
+		args []interface{}
+		str  string
+		err  error
+	}{
+		0: {in: "", args: []interface{}{StartNow, ""}, str: StartNow},
+		1: {in: StartNow, args: []interface{}{StartNow, ""}, str: StartNow},
+		2: {in: StartEarliest, args: []interface{}{startID, "0-0"}, str: StartEarliest},
+		3: {in: "15m", args: []interface{}{startAgo, "900000"}, str: "15m"},
+		4: {in: "5-3", args: []interface{}{startID, "5-2"}, str: "5-3"},
+		5: {in: "-15m", err: ErrInvalidStartPosition},
+		6: {in: "0-0", err: ErrInvalidStartPosition},
+		7: {in: "yesterday", err: ErrInvalidStartPosition},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			sp, err := ParseStartPosition(kase.in)
+			if assert.Equal(t, kase.err, err) && err == nil {
+				assert.Equal(t, kase.args, sp.scriptArgs())
+				assert.Equal(t, kase.str, sp.String())
+			}
+		})
+	}
+
+	assert.Equal(t, StartNow, StartPosition{}.String())
+	assert.Equal(t, []interface{}{StartNow, ""}, StartPosition{}.scriptArgs())
+}
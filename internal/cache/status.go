@@ -0,0 +1,370 @@
+package cache
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/log"
+)
+
+// The set of states, besides the event types, instances may report for a
+// purge request.
+const (
+	// StatePending denotes purge requests an instance is yet to reach.
+	StatePending = "pending"
+
+	// StatePassed denotes purge requests an instance's checkpoint is past,
+	// but whose outcome is no longer on record.
+	StatePassed = "passed"
+)
+
+// PurgeStatus wraps the status of a purge request.
+type PurgeStatus struct {
+	Entry
+
+	// Instances holds the status of the purge request on each instance.
+	Instances []InstanceStatus `json:"instances"`
+}
+
+// InstanceStatus wraps the status of a purge request on an instance.
+type InstanceStatus struct {
+	// Instance holds the ID of the target (see Cache.Target) of the instance.
+	Instance string `json:"instance"`
+
+	// Process holds the ID (PURGERY_ID) of the process which produced the
+	// latest event for the purge request, if any.
+	Process string `json:"process,omitempty"`
+
+	// State holds either the type of the latest event the instance produced
+	// for the purge request, StatePending or StatePassed.
+	State string `json:"state"`
+
+	// Error holds the reason the purge request failed, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Status returns the status of the purge request the given stream entry
+// carries. Status reports false for found when no such entry exists.
+func (c *Cache) Status(logger *zap.Logger, id string) (st PurgeStatus, found, ok bool) {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	vals, err := redis.Values(conn.Do("XRANGE", stream, id, id))
+	if err != nil {
+		logger.Error("failed reading purge stream entry.",
+			log.Checkpoint(id),
+			zap.Error(err))
+
+		return
+	}
+	if len(vals) == 0 {
+		return st, false, true
+	}
+	st.Entry, _ = parseEntry(vals[0])
+
+	var checkpoints map[string]string
+	if checkpoints, ok = readCheckpoints(logger, conn); !ok {
+		return
+	}
+
+	states := make(map[string]InstanceStatus, len(checkpoints))
+	for instance, cp := range checkpoints {
+		state := StatePending
+		if !lessStreamID(cp, id) {
+			state = StatePassed
+		}
+
+		states[instance] = InstanceStatus{Instance: instance, State: state}
+	}
+
+	if ok = scanResults(logger, conn, id, states); !ok {
+		return
+	}
+
+	st.Instances = make([]InstanceStatus, 0, len(states))
+	for _, s := range states {
+		st.Instances = append(st.Instances, s)
+	}
+	sort.Slice(st.Instances, func(i, j int) bool {
+		return st.Instances[i].Instance < st.Instances[j].Instance
+	})
+
+	return st, true, true
+}
+
+// scanResults records, into states, the latest result each instance recorded
+// for the given stream entry.
+func scanResults(logger *zap.Logger, conn redis.Conn, id string, states map[string]InstanceStatus) bool {
+	const batch = 500
+
+	// results are recorded after the entries they concern
+	from := id
+	for scanned := 0; scanned < maxScanned; {
+		vals, err := redis.Values(conn.Do("XRANGE", results, from, "+", "COUNT", batch))
+		if err != nil {
+			logger.Error("failed reading results stream.",
+				zap.Error(err))
+
+			return false
+		}
+
+		for _, v := range vals {
+			scanned++
+
+			e, err := decodeEntry(v)
+			if e.id != "" {
+				from = "(" + e.id
+			}
+			if err != nil {
+				continue
+			}
+
+			fields := e.fieldMap()
+			if fields["entry"] != id {
+				continue
+			}
+
+			states[fields["instance"]] = InstanceStatus{
+				Instance: fields["instance"],
+				Process:  fields["process"],
+				State:    fields["type"],
+				Error:    fields["error"],
+			}
+		}
+
+		if len(vals) < batch {
+			return true
+		}
+	}
+
+	logger.Warn("results stream scan limit reached.",
+		zap.Int("limit", maxScanned))
+
+	return true
+}
+
+// readCheckpoints returns the checkpoints of all instances, keyed by instance
+// ID.
+func readCheckpoints(logger *zap.Logger, conn redis.Conn) (map[string]string, bool) {
+	var keys []string
+
+	for cursor := "0"; ; {
+		vals, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", checkpointsPrefix+"*", "COUNT", 100))
+		if err != nil {
+			logger.Error("failed scanning checkpoints.",
+				zap.Error(err))
+
+			return nil, false
+		}
+
+		cursor, _ = redis.String(vals[0], nil)
+		batch, _ := redis.Strings(vals[1], nil)
+		keys = append(keys, batch...)
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	checkpoints := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return checkpoints, true
+	}
+
+	cps, err := redis.Strings(conn.Do("MGET", redis.Args{}.AddFlat(keys)...))
+	if err != nil {
+		logger.Error("failed reading checkpoints.",
+			zap.Error(err))
+
+		return nil, false
+	}
+
+	for i, key := range keys {
+		if cps[i] != "" { // expired in the meantime
+			checkpoints[strings.TrimPrefix(key, checkpointsPrefix)] = cps[i]
+		}
+	}
+
+	return checkpoints, true
+}
+
+// StreamInfo wraps the details of the purge stream.
+type StreamInfo struct {
+	// Length holds the number of entries in the stream.
+	Length int64 `json:"length"`
+
+	// FirstID and LastID hold the IDs of the first and last entries of the
+	// stream, if any.
+	FirstID string `json:"first_id,omitempty"`
+	LastID  string `json:"last_id,omitempty"`
+
+	// Quarantined holds the number of malformed entries which have been moved
+	// to the quarantine stream.
+	Quarantined int64 `json:"quarantined"`
+
+	// Instances holds the details of each instance consuming the stream.
+	Instances []InstanceInfo `json:"instances"`
+}
+
+// InstanceInfo wraps the details of an instance consuming the purge stream.
+type InstanceInfo struct {
+	// Instance holds the ID of the instance.
+	Instance string `json:"instance"`
+
+	// Checkpoint holds the ID of the last entry the instance processed.
+	Checkpoint string `json:"checkpoint"`
+
+	// Behind holds the number of entries which follow the checkpoint, up to
+	// maxScanned.
+	Behind int64 `json:"behind"`
+
+	// Lag holds the time between the checkpoint and the last entry.
+	Lag time.Duration `json:"lag"`
+}
+
+var behindScript = redis.NewScript(1, `
+	return #redis.call("XRANGE", KEYS[1], "(" .. ARGV[1], "+", "COUNT", ARGV[2])
+`)
+
+// Inspect returns the details of the purge stream and its consumers.
+func (c *Cache) Inspect(logger *zap.Logger) (info StreamInfo, ok bool) {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	var err error
+	if info.Length, err = redis.Int64(conn.Do("XLEN", stream)); err != nil {
+		logger.Error("failed reading purge stream length.",
+			zap.Error(err))
+
+		return
+	}
+
+	if info.Length > 0 {
+		if info.FirstID, ok = firstID(logger, conn, stream); !ok {
+			return
+		}
+		if info.LastID, ok = lastID(logger, conn, stream); !ok {
+			return
+		}
+	}
+
+	if info.Quarantined, err = redis.Int64(conn.Do("XLEN", quarantine)); err != nil {
+		logger.Error("failed reading quarantine stream length.",
+			zap.Error(err))
+
+		return info, false
+	}
+
+	var checkpoints map[string]string
+	if checkpoints, ok = readCheckpoints(logger, conn); !ok {
+		return
+	}
+
+	info.Instances = make([]InstanceInfo, 0, len(checkpoints))
+	for instance, cp := range checkpoints {
+		ii := InstanceInfo{
+			Instance:   instance,
+			Checkpoint: cp,
+		}
+
+		if ii.Behind, err = redis.Int64(behindScript.Do(conn, stream, cp, maxScanned)); err != nil {
+			logger.Error("failed counting entries behind checkpoint.",
+				zap.String("instance", instance),
+				zap.Error(err))
+
+			return info, false
+		}
+
+		if lms, _, valid := parseStreamID(info.LastID); valid {
+			if cms, _, valid := parseStreamID(cp); valid && lms > cms {
+				ii.Lag = time.Duration(lms-cms) * time.Millisecond
+			}
+		}
+
+		info.Instances = append(info.Instances, ii)
+	}
+	sort.Slice(info.Instances, func(i, j int) bool {
+		return info.Instances[i].Instance < info.Instances[j].Instance
+	})
+
+	return info, true
+}
+
+func firstID(logger *zap.Logger, conn redis.Conn, key string) (id string, ok bool) {
+	vals, err := redis.Values(conn.Do("XRANGE", key, "-", "+", "COUNT", 1))
+	if err != nil {
+		logger.Warn("failed reading first stream id.",
+			zap.String("stream", key),
+			zap.Error(err))
+
+		return
+	}
+
+	if len(vals) == 0 {
+		return "", true
+	}
+
+	// the ID of entries whose fields are malformed is still decoded
+	if e, _ := decodeEntry(vals[0]); e.id != "" {
+		return e.id, true
+	}
+
+	logger.Warn("read malformed stream entry.",
+		zap.String("stream", key))
+
+	return
+}
+
+// Replay rewinds the checkpoint of the given instance so that it reapplies the
+// purge stream entries from the given one onwards.
+//
+// Running instances pick the rewound checkpoint up the next time they write
+// their own back (see Consumer), which idle ones do every 10 seconds.
+func (c *Cache) Replay(logger *zap.Logger, instance, from string) bool {
+	conn := c.redis.Get()
+	defer conn.Close()
+
+	cp, valid := precedingStreamID(from)
+	if !valid {
+		logger.Error("invalid stream id.",
+			zap.String("id", from))
+
+		return false
+	}
+
+	logger = logger.With(zap.String("instance", instance), log.Checkpoint(cp))
+	logger.Info("rewinding checkpoint ...")
+
+	if _, err := conn.Do("SET", checkpointsPrefix+instance, cp, "EX", int(checkpointTTL/time.Second)); err != nil {
+		logger.Error("failed rewinding checkpoint.",
+			zap.Error(err))
+
+		return false
+	}
+
+	logger.Debug("checkpoint rewound.")
+
+	return true
+}
+
+// precedingStreamID returns the ID which immediately precedes the given one.
+func precedingStreamID(id string) (string, bool) {
+	ms, seq, ok := parseStreamID(id)
+	switch {
+	case !ok, ms == 0 && seq == 0:
+		return "", false
+	case seq > 0:
+		return formatStreamID(ms, seq-1), true
+	default:
+		return formatStreamID(ms-1, 1<<64-1), true
+	}
+}
+
+func formatStreamID(ms, seq uint64) string {
+	return strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq, 10)
+}
@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrecedingStreamID(t *testing.T) {
+	cases := []struct {
+		in  string
+		exp string
+		ok  bool
+	}{
+		0: {in: "", ok: false},
+		1: {in: "0-0", ok: false},
+		2: {in: "5-3", exp: "5-2", ok: true},
+		3: {in: "5-0", exp: "4-18446744073709551615", ok: true},
+		4: {in: "0-1", exp: "0-0", ok: true},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			got, ok := precedingStreamID(kase.in)
+			assert.Equal(t, kase.ok, ok)
+			assert.Equal(t, kase.exp, got)
+		})
+	}
+}
@@ -20,6 +20,9 @@ const (
 	// ECBind is returned when the application's embedded REST server fails to
 	// bind on the configured address.
 	ECBind
+
+	// ECUsage is returned when a command is invoked with invalid arguments.
+	ECUsage
 )
 
 // IsValidURL reports whether the given URL is a valid one.
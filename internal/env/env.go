@@ -2,7 +2,11 @@
 package env
 
 import (
+	"crypto/tls"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,8 +14,10 @@ import (
 	"github.com/gomodule/redigo/redis"
 	"go.uber.org/zap"
 
+	"github.com/soupedup/purgery/internal/cache"
 	"github.com/soupedup/purgery/internal/common"
 	"github.com/soupedup/purgery/internal/safe"
+	"github.com/soupedup/purgery/internal/tlsconfig"
 )
 
 // Config wraps
@@ -19,19 +25,121 @@ type Config struct {
 	// Addr holds the value of the ADDR environment variable.
 	Addr string
 
+	// SocketMode holds the permissions, as set by the UNIX_SOCKET_MODE
+	// environment variable, of the Unix domain socket the REST API binds on
+	// when Addr denotes one.
+	SocketMode os.FileMode
+
 	// APIKey holds the value of the API_KEY environment variable.
 	APIKey string
 
+	// TLS holds the TLS configuration of the embedded REST server, if any.
+	TLS *tls.Config
+
+	// TrustedProxies holds the networks the TRUSTED_PROXIES environment
+	// variable lists.
+	TrustedProxies []*net.IPNet
+
 	// PurgeryID holds the value of the PURGERY_ID environment value.
 	PurgeryID string
 
+	// TargetID holds the ID of the cache target, as set by the TARGET_ID
+	// environment variable, checkpoints are keyed to. It defaults to
+	// VarnishAddr, or to PurgeryID when VarnishAddr is a loopback or Unix
+	// domain socket address, prefixed by the REGION (or FLY_REGION) the
+	// instance runs in, if any, and by dry-run/ for dry-run instances.
+	TargetID string
+
+	// SharedTarget reports whether TargetID was set explicitly, in which case
+	// several processes may serve the target and only their leader purges.
+	SharedTarget bool
+
+	// StartPosition holds where, as set by the START_POSITION environment
+	// variable, the instance starts consuming the purge stream from in case
+	// it has no checkpoint.
+	StartPosition cache.StartPosition
+
 	// Redis holds a reference to the Redis connection pool.
 	Redis *redis.Pool
 
 	// VarnishAddr holds the value of the VARNISH_ADDR environment value.
 	VarnishAddr string
+
+	// VarnishTLS holds the TLS configuration purges are sent to Varnish
+	// with, if any.
+	VarnishTLS *tls.Config
+
+	// VarnishHeader holds the header, as set by the VARNISH_AUTH_HEADER
+	// environment variable, which is sent along with each purge request.
+	VarnishHeader http.Header
+
+	// VarnishToken holds the value of the VARNISH_TOKEN environment value.
+	VarnishToken string
+
+	// VarnishBackend holds the value of the VARNISH_BACKEND environment
+	// value; either BackendHTTP or BackendAdmin.
+	VarnishBackend string
+
+	// VarnishAdminAddr holds the value of the VARNISH_ADMIN_ADDR environment
+	// value.
+	VarnishAdminAddr string
+
+	// Warm reports whether the WARM environment value is set, in which case
+	// the cache is warmed after each purge.
+	Warm bool
+
+	// WarmPaths holds the paths the WARM_PATHS environment value lists.
+	WarmPaths []string
+
+	// WarmConcurrency holds the value of the WARM_CONCURRENCY environment
+	// value.
+	WarmConcurrency int
+
+	// VerifyHeader holds the value of the VERIFY_HEADER environment value.
+	// When set, purges are verified by checking the header.
+	VerifyHeader string
+
+	// VerifyExpect holds the value of the VERIFY_EXPECT environment value.
+	VerifyExpect string
+
+	// VerifyChanged reports whether the VERIFY_CHANGED environment value is
+	// set.
+	VerifyChanged bool
+
+	// VerifyRetries holds the value of the VERIFY_RETRIES environment value.
+	VerifyRetries int
+
+	// VarnishSecret holds the contents of the file the VARNISH_SECRET_FILE
+	// environment value points to.
+	VarnishSecret []byte
+
+	// PurgeConcurrency holds the maximum number, as set by the
+	// PURGE_CONCURRENCY environment variable, of hosts purged concurrently.
+	PurgeConcurrency int
+
+	// BacklogThreshold holds the number of pending entries, as set by the
+	// BACKLOG_THRESHOLD environment variable, above which backlogs are
+	// collapsed. Zero disables collapsing.
+	BacklogThreshold int
+
+	// DryRun reports whether the DRY_RUN environment value is set, in which
+	// case purges are logged and counted rather than applied.
+	DryRun bool
+
+	// MirrorAddr holds the value of the MIRROR_ADDR environment value. When
+	// set, purges are also sent to it.
+	MirrorAddr string
 }
 
+// The set of supported backends.
+const (
+	// BackendHTTP denotes the backend which purges via BAN requests.
+	BackendHTTP = "http"
+
+	// BackendAdmin denotes the backend which purges via the Varnish CLI.
+	BackendAdmin = "admin"
+)
+
 var redisDialOpts = []redis.DialOption{
 	redis.DialConnectTimeout(5 * time.Second),
 	redis.DialReadTimeout(3 * time.Second),
@@ -79,8 +187,382 @@ func (cfg *Config) setAPIKey(logger *zap.Logger, key string) bool {
 	return true
 }
 
+func (cfg *Config) setTrustedProxies(logger *zap.Logger, list string) bool {
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			// a single address
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			logger.Error("invalid trusted proxy.",
+				zap.String("proxy", v),
+				zap.Error(err))
+
+			return false
+		}
+
+		cfg.TrustedProxies = append(cfg.TrustedProxies, n)
+	}
+
+	return true
+}
+
+// defaultSocketMode denotes the default permissions of Unix domain sockets.
+const defaultSocketMode = 0o660
+
+func (cfg *Config) setSocketMode(logger *zap.Logger, mode string) bool {
+	if mode == "" {
+		cfg.SocketMode = defaultSocketMode
+
+		return true
+	}
+
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0o777 {
+		logger.Error("invalid UNIX_SOCKET_MODE value.",
+			zap.String("mode", mode))
+
+		return false
+	}
+	cfg.SocketMode = os.FileMode(m)
+
+	return true
+}
+
+type tlsVars struct {
+	certFile           string
+	keyFile            string
+	clientCAFile       string
+	clientCertRequired string
+}
+
+func (cfg *Config) setTLS(logger *zap.Logger, vars *tlsVars) bool {
+	switch {
+	case vars.certFile == "" && vars.keyFile == "":
+		if vars.clientCAFile != "" {
+			logger.Error("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE.")
+
+			return false
+		}
+
+		return true // plain HTTP
+	case vars.certFile == "", vars.keyFile == "":
+		logger.Error("TLS_CERT_FILE and TLS_KEY_FILE must be defined together.")
+
+		return false
+	}
+
+	require, err := parseBool(vars.clientCertRequired)
+	if err != nil {
+		logger.Error("invalid TLS_CLIENT_CERT_REQUIRED value.",
+			zap.Error(err))
+
+		return false
+	}
+
+	if cfg.TLS, err = tlsconfig.Server(logger, vars.certFile, vars.keyFile, vars.clientCAFile, require); err != nil {
+		logger.Error("failed loading tls configuration.",
+			zap.Error(err))
+
+		return false
+	}
+
+	return true
+}
+
+type varnishTLSVars struct {
+	enabled    string
+	serverName string
+	caFile     string
+}
+
+func (cfg *Config) setVarnishTLS(logger *zap.Logger, vars *varnishTLSVars) bool {
+	enabled, err := parseBool(vars.enabled)
+	switch {
+	case err != nil:
+		logger.Error("invalid VARNISH_TLS value.",
+			zap.Error(err))
+
+		return false
+	case !enabled:
+		if vars.serverName != "" || vars.caFile != "" {
+			logger.Warn("VARNISH_TLS is disabled; ignoring VARNISH_TLS_* variables.")
+		}
+
+		return true
+	}
+
+	if cfg.VarnishTLS, err = tlsconfig.Client(vars.serverName, vars.caFile); err != nil {
+		logger.Error("failed loading varnish tls configuration.",
+			zap.Error(err))
+
+		return false
+	}
+
+	return true
+}
+
+func (cfg *Config) setVarnishHeader(logger *zap.Logger, header string) bool {
+	if header == "" {
+		return true
+	}
+
+	i := strings.IndexByte(header, ':')
+	if i < 1 {
+		logger.Error("VARNISH_AUTH_HEADER must be of the form <name>: <value>.")
+
+		return false
+	}
+
+	cfg.VarnishHeader = http.Header{}
+	cfg.VarnishHeader.Set(strings.TrimSpace(header[:i]), strings.TrimSpace(header[i+1:]))
+
+	return true
+}
+
+type adminVars struct {
+	addr       string
+	secretFile string
+}
+
+func (cfg *Config) setVarnishBackend(logger *zap.Logger, backend string, vars *adminVars) bool {
+	switch backend {
+	case "", BackendHTTP:
+		cfg.VarnishBackend = BackendHTTP
+
+		return true
+	case BackendAdmin:
+		cfg.VarnishBackend = BackendAdmin
+	default:
+		logger.Error("invalid VARNISH_BACKEND value.",
+			zap.String("backend", backend))
+
+		return false
+	}
+
+	if cfg.VarnishAdminAddr = vars.addr; cfg.VarnishAdminAddr == "" {
+		logger.Error("the admin backend requires VARNISH_ADMIN_ADDR.")
+
+		return false
+	}
+
+	if vars.secretFile == "" {
+		return true // the CLI may not require authentication
+	}
+
+	var err error
+	if cfg.VarnishSecret, err = os.ReadFile(vars.secretFile); err != nil {
+		logger.Error("failed reading VARNISH_SECRET_FILE.",
+			zap.Error(err))
+
+		return false
+	}
+
+	return true
+}
+
+func (cfg *Config) setTargetID(logger *zap.Logger, region string) bool {
+	if cfg.SharedTarget = cfg.TargetID != ""; !cfg.SharedTarget {
+		if region == "" {
+			region = os.Getenv("FLY_REGION")
+		}
+
+		// processes which reach Varnish locally, i.e. sidecars, all do so via
+		// the same address, even though they each front a Varnish of their own
+		if cfg.TargetID = cfg.VarnishAddr; isLocalAddr(cfg.VarnishAddr) {
+			cfg.TargetID = cfg.PurgeryID
+		}
+
+		if region != "" {
+			cfg.TargetID = region + "/" + cfg.TargetID
+		}
+
+		if cfg.DryRun {
+			cfg.TargetID = "dry-run/" + cfg.TargetID
+		}
+	}
+
+	logger.Info("checkpoints are keyed to target.",
+		zap.String("target", cfg.TargetID),
+		zap.Bool("shared", cfg.SharedTarget))
+
+	return true
+}
+
+// isLocalAddr reports whether the given Varnish address denotes a Unix domain
+// socket or a loopback address.
+func isLocalAddr(addr string) bool {
+	if strings.HasPrefix(addr, "unix:") {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+
+	return strings.EqualFold(host, "localhost")
+}
+
+func (cfg *Config) setStartPosition(logger *zap.Logger, v string) (ok bool) {
+	var err error
+	if cfg.StartPosition, err = cache.ParseStartPosition(v); err != nil {
+		logger.Error("invalid START_POSITION value.",
+			zap.String("value", v),
+			zap.Error(err))
+
+		return false
+	}
+
+	return true
+}
+
+// defaultPurgeConcurrency denotes the default number of hosts purged
+// concurrently.
+const defaultPurgeConcurrency = 4
+
+func (cfg *Config) setPurgeConcurrency(logger *zap.Logger, v string) bool {
+	var err error
+	if cfg.PurgeConcurrency, err = parseInt(v, defaultPurgeConcurrency); err != nil || cfg.PurgeConcurrency < 1 {
+		logger.Error("invalid PURGE_CONCURRENCY value.",
+			zap.String("value", v),
+			zap.Error(err))
+
+		return false
+	}
+
+	return true
+}
+
+func (cfg *Config) setBacklogThreshold(logger *zap.Logger, v string) bool {
+	var err error
+	if cfg.BacklogThreshold, err = parseInt(v, 0); err != nil || cfg.BacklogThreshold < 0 {
+		logger.Error("invalid BACKLOG_THRESHOLD value.",
+			zap.String("value", v),
+			zap.Error(err))
+
+		return false
+	}
+
+	return true
+}
+
+func (cfg *Config) setDryRun(logger *zap.Logger, v string) (ok bool) {
+	var err error
+	if cfg.DryRun, err = parseBool(v); err != nil {
+		logger.Error("invalid DRY_RUN value.",
+			zap.Error(err))
+
+		return false
+	}
+
+	return true
+}
+
+type warmVars struct {
+	enabled     string
+	paths       string
+	concurrency string
+}
+
+// defaultWarmConcurrency denotes the default number of concurrent warm
+// requests.
+const defaultWarmConcurrency = 4
+
+func (cfg *Config) setWarm(logger *zap.Logger, vars *warmVars) bool {
+	var err error
+	if cfg.Warm, err = parseBool(vars.enabled); err != nil {
+		logger.Error("invalid WARM value.",
+			zap.Error(err))
+
+		return false
+	}
+
+	for _, p := range strings.Split(vars.paths, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			cfg.WarmPaths = append(cfg.WarmPaths, p)
+		}
+	}
+
+	if cfg.WarmConcurrency, err = parseInt(vars.concurrency, defaultWarmConcurrency); err != nil || cfg.WarmConcurrency < 1 {
+		logger.Error("invalid WARM_CONCURRENCY value.",
+			zap.String("value", vars.concurrency))
+
+		return false
+	}
+
+	return true
+}
+
+type verifyVars struct {
+	expect  string
+	changed string
+	retries string
+}
+
+func (cfg *Config) setVerify(logger *zap.Logger, vars *verifyVars) bool {
+	if cfg.VerifyHeader == "" {
+		return true // verification is disabled
+	}
+
+	var err error
+	if cfg.VerifyChanged, err = parseBool(vars.changed); err != nil {
+		logger.Error("invalid VERIFY_CHANGED value.",
+			zap.Error(err))
+
+		return false
+	}
+
+	if cfg.VerifyExpect = vars.expect; cfg.VerifyExpect == "" && !cfg.VerifyChanged {
+		logger.Error("VERIFY_HEADER requires either VERIFY_EXPECT or VERIFY_CHANGED.")
+
+		return false
+	}
+
+	if cfg.VerifyRetries, err = parseInt(vars.retries, 0); err != nil || cfg.VerifyRetries < 0 {
+		logger.Error("invalid VERIFY_RETRIES value.",
+			zap.String("value", vars.retries))
+
+		return false
+	}
+
+	return true
+}
+
+// parseInt parses the given, optional, integer value.
+func parseInt(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(v)
+}
+
+// parseBool parses the given, optional, boolean value.
+func parseBool(v string) (bool, error) {
+	if v == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(v)
+}
+
 var errLoadConfig = exit.Wrapf(common.ECLoadConfig,
-	"%/env: failed loading configuration",
+	"%s/env: failed loading configuration",
 	common.AppName)
 
 // LoadConfig returns a copy of the configuration it loads from the environment.
@@ -88,23 +570,93 @@ func LoadConfig(logger *zap.Logger) (*Config, error) {
 	logger.Info("loading configuration from the environment ...")
 
 	var (
-		cfg      Config
-		redisURL string
-		apiKey   string
+		cfg              Config
+		redisURL         string
+		apiKey           string
+		socketMode       string
+		trustedProxies   string
+		tlsFiles         tlsVars
+		varnishTLS       varnishTLSVars
+		varnishHeader    string
+		varnishBackend   string
+		varnishAdmin     adminVars
+		region           string
+		startPosition    string
+		backlogThreshold string
+		purgeConcurrency string
+		dryRun           string
+		warm             warmVars
+		verify           verifyVars
 	)
 
 	ok := []bool{
 		fetch(logger, &cfg.Addr, "ADDR"),
 
+		lookup(&socketMode, "UNIX_SOCKET_MODE") &&
+			cfg.setSocketMode(logger, socketMode),
+
 		fetch(logger, &apiKey, "API_KEY") &&
 			cfg.setAPIKey(logger, apiKey),
 
 		fetch(logger, &cfg.PurgeryID, "PURGERY_ID"),
 
+		lookup(&startPosition, "START_POSITION") &&
+			cfg.setStartPosition(logger, startPosition),
+
 		fetch(logger, &redisURL, "REDIS_URL") &&
 			cfg.dialRedis(logger, redisURL),
 
 		fetch(logger, &cfg.VarnishAddr, "VARNISH_ADDR"),
+
+		lookup(&dryRun, "DRY_RUN") &&
+			cfg.setDryRun(logger, dryRun),
+
+		lookup(&cfg.TargetID, "TARGET_ID") &&
+			lookup(&region, "REGION") &&
+			cfg.setTargetID(logger, region),
+
+		lookup(&varnishTLS.enabled, "VARNISH_TLS") &&
+			lookup(&varnishTLS.serverName, "VARNISH_TLS_SERVER_NAME") &&
+			lookup(&varnishTLS.caFile, "VARNISH_TLS_CA_FILE") &&
+			cfg.setVarnishTLS(logger, &varnishTLS),
+
+		lookup(&varnishHeader, "VARNISH_AUTH_HEADER") &&
+			cfg.setVarnishHeader(logger, varnishHeader),
+
+		lookup(&cfg.VarnishToken, "VARNISH_TOKEN"),
+
+		lookup(&varnishBackend, "VARNISH_BACKEND") &&
+			lookup(&varnishAdmin.addr, "VARNISH_ADMIN_ADDR") &&
+			lookup(&varnishAdmin.secretFile, "VARNISH_SECRET_FILE") &&
+			cfg.setVarnishBackend(logger, varnishBackend, &varnishAdmin),
+
+		lookup(&purgeConcurrency, "PURGE_CONCURRENCY") &&
+			cfg.setPurgeConcurrency(logger, purgeConcurrency),
+
+		lookup(&backlogThreshold, "BACKLOG_THRESHOLD") &&
+			cfg.setBacklogThreshold(logger, backlogThreshold),
+
+		lookup(&cfg.MirrorAddr, "MIRROR_ADDR"),
+
+		lookup(&warm.enabled, "WARM") &&
+			lookup(&warm.paths, "WARM_PATHS") &&
+			lookup(&warm.concurrency, "WARM_CONCURRENCY") &&
+			cfg.setWarm(logger, &warm),
+
+		lookup(&cfg.VerifyHeader, "VERIFY_HEADER") &&
+			lookup(&verify.expect, "VERIFY_EXPECT") &&
+			lookup(&verify.changed, "VERIFY_CHANGED") &&
+			lookup(&verify.retries, "VERIFY_RETRIES") &&
+			cfg.setVerify(logger, &verify),
+
+		lookup(&trustedProxies, "TRUSTED_PROXIES") &&
+			cfg.setTrustedProxies(logger, trustedProxies),
+
+		lookup(&tlsFiles.certFile, "TLS_CERT_FILE") &&
+			lookup(&tlsFiles.keyFile, "TLS_KEY_FILE") &&
+			lookup(&tlsFiles.clientCAFile, "TLS_CLIENT_CA_FILE") &&
+			lookup(&tlsFiles.clientCertRequired, "TLS_CLIENT_CERT_REQUIRED") &&
+			cfg.setTLS(logger, &tlsFiles),
 	}
 
 	for _, ok := range ok {
@@ -116,6 +668,29 @@ func LoadConfig(logger *zap.Logger) (*Config, error) {
 	return &cfg, nil
 }
 
+// LoadRedis returns the pool of connections to the Redis instance the
+// REDIS_URL environment variable points to.
+func LoadRedis(logger *zap.Logger) (*redis.Pool, error) {
+	var (
+		cfg      Config
+		redisURL string
+	)
+
+	if !fetch(logger, &redisURL, "REDIS_URL") || !cfg.dialRedis(logger, redisURL) {
+		return nil, errLoadConfig
+	}
+
+	return cfg.Redis, nil
+}
+
+// lookup is the counterpart of fetch for optional variables. It always reports
+// true.
+func lookup(into *string, key string) bool {
+	*into = strings.TrimSpace(os.Getenv(key))
+
+	return true
+}
+
 func fetch(logger *zap.Logger, into *string, key string) (ok bool) {
 	*into = strings.TrimSpace(os.Getenv(key))
 
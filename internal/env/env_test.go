@@ -0,0 +1,67 @@
+package env
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestSetTargetID(t *testing.T) {
+	cases := []struct {
+		cfg    Config
+		region string
+		exp    string
+		shared bool
+	}{
+		0: {
+			cfg: Config{VarnishAddr: "varnish:80", PurgeryID: "a"},
+			exp: "varnish:80",
+		},
+		1: {
+			cfg:    Config{VarnishAddr: "varnish:80", PurgeryID: "a"},
+			region: "ams",
+			exp:    "ams/varnish:80",
+		},
+		2: {
+			cfg:    Config{VarnishAddr: "127.0.0.1:6081", PurgeryID: "a"},
+			region: "ams",
+			exp:    "ams/a",
+		},
+		3: {
+			cfg: Config{VarnishAddr: "[::1]:6081", PurgeryID: "a"},
+			exp: "a",
+		},
+		4: {
+			cfg: Config{VarnishAddr: "localhost:6081", PurgeryID: "a"},
+			exp: "a",
+		},
+		5: {
+			cfg: Config{VarnishAddr: "unix:/var/run/varnish.sock", PurgeryID: "a"},
+			exp: "a",
+		},
+		6: {
+			cfg: Config{VarnishAddr: "unix:/var/run/varnish.sock", PurgeryID: "a", DryRun: true},
+			exp: "dry-run/a",
+		},
+		7: {
+			cfg:    Config{VarnishAddr: "127.0.0.1:6081", PurgeryID: "a", TargetID: "shared"},
+			exp:    "shared",
+			shared: true,
+		},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			t.Setenv("FLY_REGION", "")
+
+			cfg := kase.cfg
+			assert.True(t, cfg.setTargetID(zap.NewNop(), kase.region))
+			assert.Equal(t, kase.exp, cfg.TargetID)
+			assert.Equal(t, kase.shared, cfg.SharedTarget)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Package leader implements the leadership of the processes which serve the
+// same cache target.
+package leader
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+const (
+	// interval denotes how often the lease is acquired or renewed.
+	interval = time.Second
+
+	// leaseTTL denotes for how long the lease is held without being renewed,
+	// and thus roughly how long standbys take to take over.
+	leaseTTL = interval * 5
+)
+
+// lessor is the set of functionality leadership depends on. It's implemented
+// by *cache.Cache.
+type lessor interface {
+	AcquireLeadership(logger *zap.Logger, ttl time.Duration) (token int64, ok bool)
+	ReleaseLeadership(logger *zap.Logger)
+}
+
+var _ lessor = (*cache.Cache)(nil)
+
+// Run runs fn, until the given Context is cancelled, for as long as the process
+// leads the processes which serve the given Cache's target. Processes which
+// don't lead stand by, and take over once the leader's lease lapses.
+//
+// The Context fn is passed is cancelled once leadership is lost.
+func Run(ctx context.Context, logger *zap.Logger, c *cache.Cache, fn func(context.Context)) {
+	run(ctx, logger.With(zap.String("target", c.Target())), c, fn, interval, leaseTTL)
+}
+
+func run(ctx context.Context, logger *zap.Logger, l lessor, fn func(context.Context), interval, ttl time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		cancel  context.CancelFunc // set while leading
+		done    chan struct{}      // closed once fn returns
+		renewed time.Time
+	)
+
+	stepDown := func() {
+		cancel()
+		<-done
+		cancel = nil
+
+		l.ReleaseLeadership(logger)
+	}
+
+	for {
+		now := time.Now()
+
+		switch token, ok := l.AcquireLeadership(logger, ttl); {
+		case token > 0 && cancel == nil:
+			logger.Info("acquired leadership; leading ...",
+				zap.Int64("token", token))
+
+			var lctx context.Context
+			lctx, cancel = context.WithCancel(ctx)
+			done = make(chan struct{})
+
+			go func() {
+				defer close(done)
+
+				fn(lctx)
+			}()
+
+			renewed = now
+		case token > 0:
+			renewed = now
+		case cancel == nil:
+			logger.Debug("standing by ...")
+		case ok:
+			logger.Warn("leadership taken over; standing by ...")
+
+			stepDown()
+		case now.Sub(renewed) >= ttl-interval:
+			// the lease lapses before the next renewal could extend it
+			logger.Warn("failed renewing leadership; standing by ...")
+
+			stepDown()
+		}
+
+		select {
+		case <-ctx.Done():
+			if cancel != nil {
+				stepDown()
+			}
+
+			return
+		case <-ticker.C:
+		}
+	}
+}
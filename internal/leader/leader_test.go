@@ -0,0 +1,119 @@
+package leader
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// fakeLessor replies to each acquisition with the next of its results, and
+// keeps repeating the last one.
+type fakeLessor struct {
+	mu       sync.Mutex
+	results  []result
+	releases int
+}
+
+type result struct {
+	token int64
+	ok    bool
+}
+
+func (l *fakeLessor) AcquireLeadership(*zap.Logger, time.Duration) (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r := l.results[0]
+	if len(l.results) > 1 {
+		l.results = l.results[1:]
+	}
+
+	return r.token, r.ok
+}
+
+func (l *fakeLessor) ReleaseLeadership(*zap.Logger) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.releases++
+}
+
+func TestRun(t *testing.T) {
+	const (
+		interval = 10 * time.Millisecond
+		ttl      = 5 * interval
+	)
+
+	cases := []struct {
+		results  []result
+		runs     int
+		releases int
+	}{
+		0: { // standby
+			results: []result{{0, true}},
+		},
+		1: { // leader until cancelled
+			results:  []result{{1, true}},
+			runs:     1,
+			releases: 1,
+		},
+		2: { // taken over, then reacquired
+			results:  []result{{0, true}, {1, true}, {0, true}, {3, true}},
+			runs:     2,
+			releases: 2,
+		},
+		3: { // renewal failures which outlast the lease
+			results:  []result{{1, true}, {0, false}},
+			runs:     1,
+			releases: 1,
+		},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			l := &fakeLessor{results: kase.results}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*ttl)
+			defer cancel()
+
+			var runs int
+			run(ctx, zap.NewNop(), l, func(ctx context.Context) {
+				runs++
+				<-ctx.Done()
+			}, interval, ttl)
+
+			assert.Equal(t, kase.runs, runs)
+			assert.Equal(t, kase.releases, l.releases)
+		})
+	}
+}
+
+func TestRunStandsDownWithinTTL(t *testing.T) {
+	const (
+		interval = 10 * time.Millisecond
+		ttl      = 5 * interval
+	)
+
+	l := &fakeLessor{results: []result{{1, true}, {0, false}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*ttl)
+	defer cancel()
+
+	var (
+		started = time.Now()
+		stopped time.Time
+	)
+	run(ctx, zap.NewNop(), l, func(lctx context.Context) {
+		<-lctx.Done()
+		stopped = time.Now()
+	}, interval, ttl)
+
+	assert.Less(t, int64(stopped.Sub(started)), int64(ttl+interval))
+}
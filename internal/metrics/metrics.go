@@ -0,0 +1,39 @@
+// Package metrics implements the application's metrics, which are published
+// via expvar.
+package metrics
+
+import (
+	"expvar"
+	"io"
+	"net/http"
+
+	"github.com/soupedup/purgery/internal/common"
+)
+
+var registry = expvar.NewMap(common.AppName)
+
+// Counter returns a reference to a newly registered counter of the given name.
+//
+// Counter panics in case a counter of the given name has already been
+// registered.
+func Counter(name string) *expvar.Int {
+	if registry.Get(name) != nil {
+		panic("metrics: duplicate counter " + name)
+	}
+
+	v := new(expvar.Int)
+	registry.Set(name, v)
+
+	return v
+}
+
+// Handler returns an http.Handler which serves the application's metrics, in
+// JSON. Unlike expvar.Handler, it leaves out the variables the runtime
+// publishes (i.e. the command line the process was started with).
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		_, _ = io.WriteString(w, registry.String())
+	})
+}
@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	Counter("test_counter").Add(3)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	var got map[string]int64
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+
+	// only the application's counters are served
+	assert.Equal(t, map[string]int64{"test_counter": 3}, got)
+}
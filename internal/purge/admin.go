@@ -0,0 +1,343 @@
+package purge
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/log"
+)
+
+// The subset of Varnish CLI status codes Admin handles.
+const (
+	cliStatusOK   = 200
+	cliStatusAuth = 107
+)
+
+// adminTimeout denotes the default deadline of Varnish CLI exchanges which
+// aren't bound to a Context that carries one.
+const adminTimeout = 10 * time.Second
+
+// BanCondition denotes a condition of a ban expression, i.e. the
+// req.http.host == example.com part of a ban req.http.host == example.com
+// command.
+type BanCondition struct {
+	Field    string
+	Operator string
+	Arg      string
+}
+
+// Admin implements a client of the Varnish CLI protocol, as served on the
+// management port of Varnish (see varnishd -T).
+//
+// Instances of Admin are safe for concurrent use.
+type Admin struct {
+	addr   string
+	secret []byte
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewAdmin returns an Admin which connects to the Varnish CLI at the given
+// address and authenticates with the given secret (the contents of the file
+// varnishd -S points to).
+func NewAdmin(addr string, secret []byte) *Admin {
+	return &Admin{
+		addr:   addr,
+		secret: secret,
+	}
+}
+
+// Func returns a Func which purges by banning, via the Admin, the objects
+// each purge request is scoped to.
+//
+// Since bans can't express them, xkey-scoped purges fall back to banning the
+// host of their URL and soft purges to hard ones.
+func (a *Admin) Func() Func {
+	return func(ctx context.Context, logger *zap.Logger, pr *cache.PurgeRequest) (err error) {
+		logger = logger.With(log.URL(pr.URL), zap.String("scope", pr.Scope))
+		logger.Info("banning ...")
+
+		if pr.Soft {
+			logger.Warn("soft purges aren't supported over the cli; banning instead ...")
+		}
+
+		var conds []BanCondition
+		if conds, err = banConditions(pr); err != nil {
+			logger.Warn("failed building ban expression.",
+				zap.Error(err))
+
+			return invalidRequestError{err}
+		}
+
+		if err = a.Ban(ctx, conds...); err != nil {
+			logger.Warn("failed banning.",
+				zap.Error(err))
+
+			return
+		}
+
+		logger.Debug("banned.")
+
+		return
+	}
+}
+
+// banConditions returns the conditions of the ban which applies the given
+// purge request.
+func banConditions(pr *cache.PurgeRequest) ([]BanCondition, error) {
+	u, err := url.Parse(pr.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	conds := []BanCondition{
+		{"req.http.host", "==", u.Host},
+	}
+
+	switch pr.Scope {
+	case cache.ScopeExact:
+		conds = append(conds, BanCondition{"req.url", "==", u.RequestURI()})
+	case cache.ScopePrefix, cache.ScopeRegex:
+		pattern, err := Pattern(pr)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, BanCondition{"req.url", "~", pattern})
+	}
+
+	return conds, nil
+}
+
+// Ban adds a ban consisting of the given conditions, which are joined via &&.
+func (a *Admin) Ban(ctx context.Context, conds ...BanCondition) error {
+	if len(conds) == 0 {
+		return errEmptyBan
+	}
+
+	args := []string{"ban"}
+	for i, c := range conds {
+		if i > 0 {
+			args = append(args, "&&")
+		}
+		args = append(args, c.Field, c.Operator, c.Arg)
+	}
+
+	_, err := a.Do(ctx, args...)
+
+	return err
+}
+
+var errEmptyBan = errors.New("purge: ban without conditions")
+
+// BanList returns the output of the ban.list command.
+func (a *Admin) BanList(ctx context.Context) (string, error) {
+	return a.Do(ctx, "ban.list")
+}
+
+// Do runs the given command and returns its output. Commands which report a
+// status other than 200 result in a *CLIError.
+func (a *Admin) Do(ctx context.Context, args ...string) (body string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.conn == nil {
+		if err = a.connect(ctx); err != nil {
+			return
+		}
+	}
+
+	if body, err = a.do(ctx, args); err != nil {
+		if _, isCLI := err.(*CLIError); !isCLI {
+			// the connection is in an unknown state
+			a.close()
+		}
+	}
+
+	return
+}
+
+func (a *Admin) connect(ctx context.Context) (err error) {
+	var d net.Dialer
+	if a.conn, err = d.DialContext(ctx, "tcp", a.addr); err != nil {
+		return
+	}
+	a.r = bufio.NewReader(a.conn)
+
+	defer func() {
+		if err != nil {
+			a.close()
+		}
+	}()
+
+	if err = a.setDeadline(ctx); err != nil {
+		return
+	}
+
+	var (
+		status int
+		body   string
+	)
+	if status, body, err = a.read(); err != nil {
+		return
+	}
+
+	switch status {
+	case cliStatusOK:
+		return // no authentication required
+	case cliStatusAuth:
+		break
+	default:
+		return &CLIError{Status: status, Body: body}
+	}
+
+	i := strings.IndexByte(body, '\n')
+	if i < 0 {
+		return fmt.Errorf("purge: malformed cli challenge (%q)", body)
+	}
+
+	_, err = a.do(ctx, []string{"auth", authResponse(body[:i], a.secret)})
+
+	return
+}
+
+// authResponse returns the response to the given CLI challenge.
+func authResponse(challenge string, secret []byte) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, challenge+"\n")
+	_, _ = h.Write(secret)
+	_, _ = io.WriteString(h, challenge+"\n")
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (a *Admin) close() {
+	_ = a.conn.Close()
+	a.conn, a.r = nil, nil
+}
+
+func (a *Admin) setDeadline(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(adminTimeout)
+	}
+
+	return a.conn.SetDeadline(deadline)
+}
+
+func (a *Admin) do(ctx context.Context, args []string) (string, error) {
+	if err := a.setDeadline(ctx); err != nil {
+		return "", err
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+
+	if _, err := io.WriteString(a.conn, strings.Join(quoted, " ")+"\n"); err != nil {
+		return "", err
+	}
+
+	status, body, err := a.read()
+	if err == nil && status != cliStatusOK {
+		err = &CLIError{Status: status, Body: body}
+	}
+
+	return body, err
+}
+
+// maxResponseLength denotes the maximum length of the CLI responses read.
+// varnishd truncates them to cli_limit, which defaults to 48KB.
+const maxResponseLength = 1 << 20
+
+// read reads a CLI response, which consists of a "<status> <length>\n" header
+// followed by length bytes of body and a newline.
+func (a *Admin) read() (status int, body string, err error) {
+	var header string
+	if header, err = a.r.ReadString('\n'); err != nil {
+		return
+	}
+
+	fields := strings.Fields(header)
+	if len(fields) != 2 {
+		err = fmt.Errorf("purge: malformed cli response header (%q)", header)
+
+		return
+	}
+
+	var length int
+	if status, err = strconv.Atoi(fields[0]); err != nil {
+		return
+	}
+	if length, err = strconv.Atoi(fields[1]); err != nil {
+		return
+	}
+	if length < 0 || length > maxResponseLength {
+		err = fmt.Errorf("purge: invalid cli response length (%d)", length)
+
+		return
+	}
+
+	buf := make([]byte, length+1) // the body is followed by a newline
+	if _, err = io.ReadFull(a.r, buf); err != nil {
+		return
+	}
+
+	return status, string(buf[:length]), nil
+}
+
+// quoteArg quotes the given CLI argument, when required.
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\r\"\\{}") {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// CLIError is returned by Admin when Varnish responds with a status other
+// than 200.
+type CLIError struct {
+	Status int
+	Body   string
+}
+
+// Error implements error for CLIError.
+func (err *CLIError) Error() string {
+	return fmt.Sprintf("purge: varnish cli status %d: %s", err.Status, strings.TrimSpace(err.Body))
+}
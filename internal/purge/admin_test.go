@@ -0,0 +1,342 @@
+package purge
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+func TestAdmin(t *testing.T) {
+	cli := newFakeCLI(t, "s3cr3t\n")
+
+	admin := NewAdmin(cli.addr(), []byte("s3cr3t\n"))
+	ctx := context.Background()
+
+	require.NoError(t, admin.Ban(ctx,
+		BanCondition{"req.http.host", "==", "example.com"},
+		BanCondition{"req.url", "~", `^/a "quoted" path\`},
+	))
+
+	require.NoError(t, admin.Func()(ctx, zap.NewNop(), &cache.PurgeRequest{URL: "http://example.org/some/path"}))
+	require.NoError(t, admin.Func()(ctx, zap.NewNop(), &cache.PurgeRequest{
+		URL:   "http://example.org/some/path",
+		Scope: cache.ScopeExact,
+	}))
+
+	list, err := admin.BanList(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		`req.http.host == example.com && req.url ~ ^/a "quoted" path\`,
+		`req.http.host == example.org`,
+		`req.http.host == example.org && req.url == /some/path`,
+	}, "\n"), list)
+
+	_, err = admin.Do(ctx, "bogus")
+	assert.Equal(t, &CLIError{Status: 101, Body: "Unknown request."}, err)
+
+	// command errors don't cost us the connection
+	_, err = admin.BanList(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cli.connections())
+
+	assert.Equal(t, errEmptyBan, admin.Ban(ctx))
+}
+
+func TestAdminReconnects(t *testing.T) {
+	cli := newFakeCLI(t, "secret")
+	admin := NewAdmin(cli.addr(), []byte("secret"))
+
+	ctx := context.Background()
+	require.NoError(t, admin.Ban(ctx, BanCondition{"req.url", "==", "/"}))
+
+	cli.dropConnections()
+
+	// the first command fails on the broken connection while the next one
+	// redials
+	_ = admin.Ban(ctx, BanCondition{"req.url", "==", "/"})
+	require.NoError(t, admin.Ban(ctx, BanCondition{"req.url", "==", "/"}))
+	assert.Equal(t, 2, cli.connections())
+}
+
+func TestAdminWrongSecret(t *testing.T) {
+	cli := newFakeCLI(t, "secret")
+	admin := NewAdmin(cli.addr(), []byte("wrong"))
+
+	err := admin.Ban(context.Background(), BanCondition{"req.url", "==", "/"})
+	assert.Equal(t, &CLIError{Status: 107, Body: "Authentication required."}, err)
+}
+
+func TestBanConditions(t *testing.T) {
+	cases := []struct {
+		pr  cache.PurgeRequest
+		exp []BanCondition
+	}{
+		0: {
+			pr: cache.PurgeRequest{URL: "http://example.com/a"},
+			exp: []BanCondition{
+				{"req.http.host", "==", "example.com"},
+			},
+		},
+		1: {
+			pr: cache.PurgeRequest{URL: "http://example.com/a?b", Scope: cache.ScopeExact},
+			exp: []BanCondition{
+				{"req.http.host", "==", "example.com"},
+				{"req.url", "==", "/a?b"},
+			},
+		},
+		2: {
+			pr: cache.PurgeRequest{URL: "http://example.com/a.b", Scope: cache.ScopePrefix},
+			exp: []BanCondition{
+				{"req.http.host", "==", "example.com"},
+				{"req.url", "~", `^/a\.b`},
+			},
+		},
+		3: {
+			pr: cache.PurgeRequest{URL: "http://example.com/", Scope: cache.ScopeRegex, Pattern: "^/img/"},
+			exp: []BanCondition{
+				{"req.http.host", "==", "example.com"},
+				{"req.url", "~", "^/img/"},
+			},
+		},
+		4: {
+			pr: cache.PurgeRequest{URL: "http://example.com/", Scope: cache.ScopeXKey, Keys: []string{"a"}},
+			exp: []BanCondition{
+				{"req.http.host", "==", "example.com"},
+			},
+		},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			got, err := banConditions(&kase.pr)
+			require.NoError(t, err)
+			assert.Equal(t, kase.exp, got)
+		})
+	}
+}
+
+func TestQuoteArg(t *testing.T) {
+	cases := map[string]string{
+		"req.url":  "req.url",
+		"":         `""`,
+		"a b":      `"a b"`,
+		`a"b`:      `"a\"b"`,
+		`a\b`:      `"a\\b"`,
+		"a\nb\tc":  `"a\nb\tc"`,
+		"{braces}": `"{braces}"`,
+	}
+
+	for in, exp := range cases {
+		assert.Equal(t, exp, quoteArg(in), "in: %q", in)
+	}
+}
+
+func TestAdminRead(t *testing.T) {
+	cases := []struct {
+		response string
+		status   int
+		body     string
+		err      bool
+	}{
+		0: {response: "200 2\nok\n", status: 200, body: "ok"},
+		1: {response: "200 -1\nok\n", err: true},
+		2: {response: "200 " + strconv.Itoa(maxResponseLength+1) + "\n", err: true},
+		3: {response: "200 99999999999999999999\n", err: true},
+		4: {response: "200\n", err: true},
+		5: {response: "200 8\nok\n", err: true},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			a := &Admin{
+				r: bufio.NewReader(strings.NewReader(kase.response)),
+			}
+
+			status, body, err := a.read()
+			if kase.err {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, kase.status, status)
+			assert.Equal(t, kase.body, body)
+		})
+	}
+}
+
+// fakeCLI implements a subset of the Varnish CLI protocol.
+type fakeCLI struct {
+	t      *testing.T
+	l      net.Listener
+	secret string
+
+	mu    sync.Mutex
+	bans  []string
+	conns []net.Conn
+}
+
+func newFakeCLI(t *testing.T, secret string) *fakeCLI {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	cli := &fakeCLI{
+		t:      t,
+		l:      l,
+		secret: secret,
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+		cli.dropConnections()
+	})
+
+	go cli.serve()
+
+	return cli
+}
+
+func (cli *fakeCLI) addr() string {
+	return cli.l.Addr().String()
+}
+
+func (cli *fakeCLI) connections() int {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+
+	return len(cli.conns)
+}
+
+func (cli *fakeCLI) dropConnections() {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+
+	for _, conn := range cli.conns {
+		_ = conn.Close()
+	}
+}
+
+func (cli *fakeCLI) serve() {
+	for {
+		conn, err := cli.l.Accept()
+		if err != nil {
+			return
+		}
+
+		cli.mu.Lock()
+		cli.conns = append(cli.conns, conn)
+		cli.mu.Unlock()
+
+		go cli.handle(conn)
+	}
+}
+
+func (cli *fakeCLI) handle(conn net.Conn) {
+	defer conn.Close()
+
+	const challenge = "abcdefghijklmnopqrstuvwxyzabcdef"
+
+	respond := func(status int, body string) {
+		fmt.Fprintf(conn, "%-3d %-8d\n%s\n", status, len(body), body)
+	}
+	respond(cliStatusAuth, challenge+"\n\nAuthentication required.")
+
+	authenticated := false
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := tokenize(strings.TrimSuffix(line, "\n"))
+
+		switch {
+		case len(args) == 2 && args[0] == "auth":
+			if args[1] != authResponse(challenge, []byte(cli.secret)) {
+				respond(cliStatusAuth, "Authentication required.")
+
+				continue
+			}
+			authenticated = true
+			respond(cliStatusOK, "-----------------------------\nVarnish Cache CLI 1.0")
+		case !authenticated:
+			respond(cliStatusAuth, "Authentication required.")
+		case len(args) > 3 && args[0] == "ban":
+			cli.mu.Lock()
+			cli.bans = append(cli.bans, strings.Join(args[1:], " "))
+			cli.mu.Unlock()
+
+			respond(cliStatusOK, "")
+		case len(args) == 1 && args[0] == "ban.list":
+			cli.mu.Lock()
+			list := strings.Join(cli.bans, "\n")
+			cli.mu.Unlock()
+
+			respond(cliStatusOK, list)
+		default:
+			respond(101, "Unknown request.")
+		}
+	}
+}
+
+// tokenize splits the given CLI command line into its arguments.
+func tokenize(line string) (args []string) {
+	var (
+		cur    strings.Builder
+		quoted bool
+		inArg  bool
+	)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case quoted && c == '\\' && i+1 < len(line):
+			i++
+			switch line[i] {
+			case 'n':
+				cur.WriteByte('\n')
+			case 'r':
+				cur.WriteByte('\r')
+			case 't':
+				cur.WriteByte('\t')
+			default:
+				cur.WriteByte(line[i])
+			}
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && c == ' ':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, cur.String())
+	}
+
+	return
+}
@@ -0,0 +1,215 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/common"
+	"github.com/soupedup/purgery/internal/log"
+	"github.com/soupedup/purgery/internal/metrics"
+)
+
+var (
+	collapsedBacklogs = metrics.Counter("collapsed_backlogs")
+	collapsedEntries  = metrics.Counter("collapsed_entries")
+)
+
+// maxCollapsed denotes the maximum number of entries a single backlog collapse
+// spans. Larger backlogs are collapsed in turns.
+const maxCollapsed = 10000
+
+// RunOption denotes the set of options Run accepts.
+type RunOption func(*runOptions)
+
+type runOptions struct {
+	collapseAbove int
+	concurrency   int
+}
+
+func newRunOptions(opts []RunOption) *runOptions {
+	o := new(runOptions)
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithBacklogCollapse configures Run to collapse backlogs of more than
+// threshold entries, i.e. those instances face after outages.
+//
+// Rather than purging each entry of the backlog in turn, the hosts the backlog
+// concerns are purged once each, along with its distinct xkey-scoped purges,
+// and the checkpoint then jumps past the backlog.
+func WithBacklogCollapse(threshold int) RunOption {
+	return func(o *runOptions) {
+		o.collapseAbove = threshold
+	}
+}
+
+// collapse collapses the backlog, in case it's larger than the configured
+// threshold. It reports whether it did so, along with the size of the backlog
+// it probed.
+func (fn Func) collapse(ctx context.Context, logger *zap.Logger, c *cache.Cache, cs *cache.Consumer, threshold int) (backlog int, collapsed, ok bool) {
+	if backlog, ok = cs.Backlog(logger, threshold+1); !ok || backlog <= threshold {
+		return
+	}
+
+	logger.Warn("collapsing backlog ...",
+		zap.Int("threshold", threshold))
+
+	last, batch, ok := cs.Pending(logger, maxCollapsed)
+	if !ok || last == "" {
+		return backlog, false, ok
+	}
+
+	prs, escalated := collapsePurges(batch)
+	for _, pr := range prs {
+		var ve *VerifyError
+
+		if err := fn(ctx, logger, pr); err != nil && !errors.As(err, &ve) {
+			logger.Error("failed collapsing backlog.",
+				log.URL(pr.URL),
+				zap.Error(err))
+
+			return backlog, true, false
+		}
+	}
+
+	if !cs.Jump(logger, last) {
+		return backlog, true, false
+	}
+
+	results := make([]cache.Result, len(batch))
+	for i := range batch {
+		e := &batch[i]
+
+		typ := cache.EventCollapsed
+		if !common.IsValidURL(e.URL) || !cache.IsValidScope(e.Scope) {
+			typ = cache.EventDropped
+		}
+		results[i] = cache.Result{Entry: e.ID, URL: e.URL, Type: typ}
+	}
+	c.RecordResults(logger, results)
+
+	collapsedBacklogs.Add(1)
+	collapsedEntries.Add(int64(len(batch)))
+
+	hosts := make([]string, 0, len(prs))
+	for _, pr := range prs {
+		if pr.Scope == cache.ScopeHost {
+			hosts = append(hosts, hostOf(pr.URL))
+		}
+	}
+
+	// the entries which were purged more broadly than they requested, by scope
+	fields := []zap.Field{
+		zap.Int("entries", len(batch)),
+		zap.Int("purges", len(prs)),
+		zap.Strings("hosts", hosts),
+		log.Checkpoint(last),
+		zap.Namespace("escalated"),
+	}
+	for _, scope := range []string{cache.ScopeExact, cache.ScopePrefix, cache.ScopeRegex, "soft"} {
+		if n := escalated[scope]; n > 0 {
+			fields = append(fields, zap.Int(scope, n))
+		}
+	}
+
+	logger.Warn("collapsed backlog.", fields...)
+
+	return backlog, true, true
+}
+
+// collapsePurges returns the purges which cover those of the given batch: one
+// per host, of the root of the host under the scheme (and port) it was first
+// seen with, followed by the distinct xkey-scoped purges of the batch, which
+// are kept as they are. Invalid purge requests are left out.
+//
+// It also returns the number of purges, by scope, which the host purges
+// escalate, along with the number of soft purges they harden (as soft).
+func collapsePurges(batch []cache.Entry) (prs []*cache.PurgeRequest, escalated map[string]int) {
+	var (
+		hosts []string
+		roots = make(map[string]string) // URLs of the roots of hosts, by host
+		xkeys []*cache.PurgeRequest
+		seen  = make(map[string]bool) // xkey-scoped purges, by soft flag & keys
+	)
+	escalated = make(map[string]int)
+
+	for i := range batch {
+		pr := &batch[i].PurgeRequest
+		if !common.IsValidURL(pr.URL) || !cache.IsValidScope(pr.Scope) {
+			continue
+		}
+
+		if pr.Scope == cache.ScopeXKey {
+			keys := append([]string(nil), pr.Keys...)
+			sort.Strings(keys)
+
+			if id := strconv.FormatBool(pr.Soft) + " " + strings.Join(keys, " "); !seen[id] {
+				seen[id] = true
+				xkeys = append(xkeys, &cache.PurgeRequest{
+					URL:   pr.URL,
+					Scope: cache.ScopeXKey,
+					Keys:  pr.Keys,
+					Soft:  pr.Soft,
+				})
+			}
+
+			continue
+		}
+
+		if pr.Scope != cache.ScopeHost {
+			escalated[pr.Scope]++
+		}
+		if pr.Soft {
+			escalated["soft"]++
+		}
+
+		if host := hostOf(pr.URL); roots[host] == "" {
+			roots[host] = rootOf(pr.URL)
+			hosts = append(hosts, host)
+		}
+	}
+
+	sort.Strings(hosts)
+
+	prs = make([]*cache.PurgeRequest, 0, len(hosts)+len(xkeys))
+	for _, host := range hosts {
+		prs = append(prs, &cache.PurgeRequest{
+			URL:   roots[host],
+			Scope: cache.ScopeHost,
+		})
+	}
+	prs = append(prs, xkeys...)
+
+	return
+}
+
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Host)
+}
+
+// rootOf returns the URL of the root of the host of the given URL.
+func rootOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + "/"
+}
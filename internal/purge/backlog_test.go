@@ -0,0 +1,147 @@
+package purge
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+func TestCollapsePurges(t *testing.T) {
+	entry := func(url, scope string, keys ...string) cache.Entry {
+		return cache.Entry{
+			PurgeRequest: cache.PurgeRequest{URL: url, Scope: scope, Keys: keys},
+		}
+	}
+
+	soft := entry("http://example.org/b", cache.ScopeRegex)
+	soft.Soft = true
+	softKeys := entry("http://example.net/", cache.ScopeXKey, "b", "a")
+	softKeys.Soft = true
+
+	got, escalated := collapsePurges([]cache.Entry{
+		entry("http://example.org/a", cache.ScopeExact),
+		entry("http://example.com/a", cache.ScopePrefix),
+		entry("http://example.com:8080/a", cache.ScopeExact),
+		entry("http://EXAMPLE.com/b", cache.ScopeHost),
+		soft,
+		entry("http://example.net/", cache.ScopeXKey, "b", "a"),
+		entry("http://example.com/", cache.ScopeXKey, "c", "a"),
+		entry("http://example.com/", cache.ScopeXKey, "a", "b"), // a duplicate
+		softKeys,
+		entry("ftp://example.io/", cache.ScopeHost),
+		entry("http://example.io/", "foo"),
+	})
+
+	assert.Equal(t, []*cache.PurgeRequest{
+		{URL: "http://example.com/", Scope: cache.ScopeHost},
+		{URL: "http://example.com:8080/", Scope: cache.ScopeHost},
+		{URL: "http://example.org/", Scope: cache.ScopeHost},
+		{URL: "http://example.net/", Scope: cache.ScopeXKey, Keys: []string{"b", "a"}},
+		{URL: "http://example.com/", Scope: cache.ScopeXKey, Keys: []string{"c", "a"}},
+		{URL: "http://example.net/", Scope: cache.ScopeXKey, Keys: []string{"b", "a"}, Soft: true},
+	}, got)
+
+	assert.Equal(t, map[string]int{
+		cache.ScopeExact:  2,
+		cache.ScopePrefix: 1,
+		cache.ScopeRegex:  1,
+		"soft":            1,
+	}, escalated)
+
+	got, escalated = collapsePurges(nil)
+	assert.Empty(t, got)
+	assert.Empty(t, escalated)
+}
+
+func TestCollapse(t *testing.T) {
+	cases := []struct {
+		threshold int
+		collapsed bool
+		cp        string // as stored
+		purged    []string
+	}{
+		0: {threshold: 6, cp: "1-0"},
+		1: {
+			threshold: 5,
+			collapsed: true,
+			cp:        "1-6",
+			purged:    []string{"http://a.com/", "http://b.com:8080/", "http://a.com/x"},
+		},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			conn := newStreamConn(
+				&cache.PurgeRequest{URL: "http://a.com/1", Scope: cache.ScopeExact},
+				&cache.PurgeRequest{URL: "http://b.com:8080/1", Scope: cache.ScopePrefix},
+				&cache.PurgeRequest{URL: "http://a.com/x", Scope: cache.ScopeXKey, Keys: []string{"k"}},
+				&cache.PurgeRequest{URL: "http://A.com/2", Scope: cache.ScopeHost},
+				&cache.PurgeRequest{URL: "http://c.com/", Scope: "foo"},
+				&cache.PurgeRequest{Scope: cache.ScopeHost}, // malformed
+			)
+			c := conn.newCache()
+
+			var (
+				mu     sync.Mutex
+				purged []string
+			)
+			fn := Func(func(_ context.Context, _ *zap.Logger, pr *cache.PurgeRequest) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				purged = append(purged, pr.URL)
+
+				return nil
+			})
+
+			logger := zap.NewNop()
+			cs := c.NewConsumer(cache.DefaultFlushCount, time.Hour)
+			defer cs.Close(logger)
+
+			backlogs, entries := collapsedBacklogs.Value(), collapsedEntries.Value()
+
+			backlog, collapsed, ok := fn.collapse(context.Background(), logger, c, cs, kase.threshold)
+			require.True(t, ok)
+			assert.Equal(t, 6, backlog)
+			assert.Equal(t, kase.collapsed, collapsed)
+			assert.Equal(t, kase.purged, purged)
+
+			// the checkpoint jumps past the backlog right away
+			assert.Equal(t, kase.cp, conn.cp)
+
+			if !kase.collapsed {
+				assert.Empty(t, conn.results)
+				assert.Empty(t, conn.quarantined)
+				assert.Equal(t, backlogs, collapsedBacklogs.Value())
+				assert.Equal(t, entries, collapsedEntries.Value())
+
+				return
+			}
+
+			assert.Equal(t, backlogs+1, collapsedBacklogs.Value())
+			assert.Equal(t, entries+5, collapsedEntries.Value())
+			assert.Equal(t, []string{"1-6"}, conn.quarantined)
+
+			for id, typ := range map[string]string{
+				"1-1": cache.EventCollapsed,
+				"1-2": cache.EventCollapsed,
+				"1-3": cache.EventCollapsed,
+				"1-4": cache.EventCollapsed,
+				"1-5": cache.EventDropped,
+				"1-6": cache.EventQuarantined,
+			} {
+				assert.Equal(t, typ, conn.result(id), id)
+			}
+		})
+	}
+}
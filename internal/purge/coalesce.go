@@ -0,0 +1,134 @@
+package purge
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/metrics"
+)
+
+var coalescedPurges = metrics.Counter("coalesced_purges")
+
+// group wraps a purge request along with the purge requests its effect covers.
+// Both are denoted by their index in the batch they're part of.
+type group struct {
+	purge   int
+	covered []int
+}
+
+// target wraps the parts of a purge request coalescing considers.
+type target struct {
+	*cache.PurgeRequest
+	host string
+	uri  string
+}
+
+func newTarget(pr *cache.PurgeRequest) (t target) {
+	t.PurgeRequest = pr
+
+	if u, err := url.Parse(pr.URL); err == nil {
+		t.host = strings.ToLower(u.Host)
+		t.uri = u.RequestURI()
+	}
+
+	return
+}
+
+// coalesce groups the given purge requests so that only those whose effect no
+// other purge request of the batch covers need be applied. Of purge requests
+// with the same effect, the earliest is applied.
+func coalesce(batch []*cache.PurgeRequest) []group {
+	targets := make([]target, len(batch))
+	for i, pr := range batch {
+		targets[i] = newTarget(pr)
+	}
+
+	// a purge request is covered when another one covers it, unless they cover
+	// each other and it comes first
+	covered := make([]bool, len(targets))
+	for i := range targets {
+		for j := range targets {
+			if i != j && covers(&targets[j], &targets[i]) && (j < i || !covers(&targets[i], &targets[j])) {
+				covered[i] = true
+
+				break
+			}
+		}
+	}
+
+	groups := make([]group, 0, len(targets))
+	index := make(map[int]int, len(targets)) // group index, by purge index
+	for i := range targets {
+		if !covered[i] {
+			index[i] = len(groups)
+			groups = append(groups, group{purge: i})
+		}
+	}
+
+	// since covering is transitive, a purge request which covers each covered
+	// one is itself not covered.
+	for i := range targets {
+		if !covered[i] {
+			continue
+		}
+
+		for j := range targets {
+			if !covered[j] && covers(&targets[j], &targets[i]) {
+				g := &groups[index[j]]
+				g.covered = append(g.covered, i)
+
+				break
+			}
+		}
+	}
+
+	return groups
+}
+
+// covers reports whether the effect of purge a covers that of purge b.
+func covers(a, b *target) bool {
+	if a.Soft && !b.Soft {
+		return false // soft purges only expire objects
+	}
+
+	if a.Scope == cache.ScopeXKey || b.Scope == cache.ScopeXKey {
+		return a.Scope == b.Scope && containsAll(a.Keys, b.Keys)
+	}
+
+	if a.host == "" || a.host != b.host {
+		return false
+	}
+
+	switch a.Scope {
+	case cache.ScopeHost:
+		return true
+	case cache.ScopePrefix:
+		return (b.Scope == cache.ScopePrefix || b.Scope == cache.ScopeExact) &&
+			strings.HasPrefix(b.uri, a.uri)
+	case cache.ScopeExact:
+		return b.Scope == cache.ScopeExact && a.uri == b.uri
+	case cache.ScopeRegex:
+		return b.Scope == cache.ScopeRegex && a.Pattern == b.Pattern
+	default:
+		return false
+	}
+}
+
+// containsAll reports whether set contains every one of keys.
+func containsAll(set, keys []string) bool {
+	for _, k := range keys {
+		found := false
+		for _, s := range set {
+			if found = s == k; found {
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,95 @@
+package purge
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+func TestCoalesce(t *testing.T) {
+	host := func(url string) *cache.PurgeRequest {
+		return &cache.PurgeRequest{URL: url, Scope: cache.ScopeHost}
+	}
+	prefix := func(url string) *cache.PurgeRequest {
+		return &cache.PurgeRequest{URL: url, Scope: cache.ScopePrefix}
+	}
+	exact := func(url string, soft bool) *cache.PurgeRequest {
+		return &cache.PurgeRequest{URL: url, Scope: cache.ScopeExact, Soft: soft}
+	}
+	xkey := func(soft bool, keys ...string) *cache.PurgeRequest {
+		return &cache.PurgeRequest{URL: "http://example.com/", Scope: cache.ScopeXKey, Keys: keys, Soft: soft}
+	}
+
+	cases := []struct {
+		batch []*cache.PurgeRequest
+		exp   []group
+	}{
+		0: {
+			exp: []group{},
+		},
+		1: { // same host
+			batch: []*cache.PurgeRequest{
+				host("http://example.com/a"),
+				host("http://EXAMPLE.com/b"),
+				host("http://example.org/a"),
+				host("http://example.com/c"),
+			},
+			exp: []group{{purge: 0, covered: []int{1, 3}}, {purge: 2}},
+		},
+		2: { // covered prefixes
+			batch: []*cache.PurgeRequest{
+				prefix("http://example.com/a/b"),
+				prefix("http://example.com/a"),
+				exact("http://example.com/a/c", false),
+				exact("http://example.com/b", false),
+				prefix("http://example.org/a/b"),
+			},
+			exp: []group{{purge: 1, covered: []int{0, 2}}, {purge: 3}, {purge: 4}},
+		},
+		3: { // hosts cover all but xkey purges
+			batch: []*cache.PurgeRequest{
+				exact("http://example.com/a", true),
+				xkey(false, "a"),
+				prefix("http://example.com/a"),
+				host("http://example.com/"),
+			},
+			exp: []group{{purge: 1}, {purge: 3, covered: []int{0, 2}}},
+		},
+		4: { // soft purges don't cover hard ones
+			batch: []*cache.PurgeRequest{
+				exact("http://example.com/a", true),
+				exact("http://example.com/a", false),
+				exact("http://example.com/a", true),
+			},
+			exp: []group{{purge: 1, covered: []int{0, 2}}},
+		},
+		5: { // keys
+			batch: []*cache.PurgeRequest{
+				xkey(false, "a"),
+				xkey(false, "a", "b"),
+				xkey(true, "a", "b", "c"),
+				xkey(true, "c"),
+			},
+			exp: []group{{purge: 1, covered: []int{0}}, {purge: 2, covered: []int{3}}},
+		},
+		6: { // same patterns
+			batch: []*cache.PurgeRequest{
+				{URL: "http://example.com/", Scope: cache.ScopeRegex, Pattern: "^/a"},
+				{URL: "http://example.com/", Scope: cache.ScopeRegex, Pattern: "^/b"},
+				{URL: "http://example.com/x", Scope: cache.ScopeRegex, Pattern: "^/a"},
+			},
+			exp: []group{{purge: 0, covered: []int{2}}, {purge: 1}},
+		},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			assert.Equal(t, kase.exp, coalesce(kase.batch))
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+// errSkipped is the error dispatch reports for the purges it didn't apply,
+// since a preceding purge of the same host failed.
+var errSkipped = errors.New("purge: skipped after a preceding purge failed")
+
+// WithConcurrency configures Run to apply the purges of different hosts
+// concurrently, via up to n workers. Purges of the same host are still applied
+// in order.
+func WithConcurrency(n int) RunOption {
+	return func(o *runOptions) {
+		o.concurrency = n
+	}
+}
+
+// dispatchKey returns the key purges are serialized by. Purges of the same
+// host share a key, as do xkey-scoped purges, which span hosts.
+func dispatchKey(pr *cache.PurgeRequest) string {
+	if pr.Scope == cache.ScopeXKey {
+		return cache.ScopeXKey
+	}
+
+	return "host:" + hostOf(pr.URL)
+}
+
+// dispatch applies the purges of the given groups via up to concurrency
+// workers, and returns the error each group resulted in.
+//
+// Groups of the same dispatch key are applied in order, and once one of them
+// fails (as opposed to failing verification) those that follow it result in
+// errSkipped.
+func (fn Func) dispatch(ctx context.Context, logger *zap.Logger, prs []*cache.PurgeRequest, groups []group, concurrency int) []error {
+	errs := make([]error, len(groups))
+
+	var (
+		keys   []string
+		queues = make(map[string][]int) // group indices, by dispatch key
+	)
+	for i := range groups {
+		key := dispatchKey(prs[groups[i].purge])
+		if _, ok := queues[key]; !ok {
+			keys = append(keys, key)
+		}
+		queues[key] = append(queues[key], i)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(keys) {
+		concurrency = len(keys)
+	}
+
+	work := make(chan []int, len(keys))
+	for _, key := range keys {
+		work <- queues[key]
+	}
+	close(work)
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+
+			for queue := range work {
+				var failed bool
+
+				for _, i := range queue {
+					if failed {
+						errs[i] = errSkipped
+
+						continue
+					}
+
+					var ve *VerifyError
+					if err := fn(ctx, logger, prs[groups[i].purge]); err != nil {
+						errs[i] = err
+						failed = !errors.As(err, &ve)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
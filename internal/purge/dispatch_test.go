@@ -0,0 +1,99 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+func TestDispatch(t *testing.T) {
+	prs := []*cache.PurgeRequest{
+		{URL: "http://a.com/1", Scope: cache.ScopeExact},
+		{URL: "http://b.com/1", Scope: cache.ScopeExact},
+		{URL: "http://a.com/fail", Scope: cache.ScopeExact},
+		{URL: "http://b.com/2", Scope: cache.ScopeExact},
+		{URL: "http://a.com/2", Scope: cache.ScopeExact},
+		{URL: "http://c.com/unverified", Scope: cache.ScopeExact},
+		{URL: "http://c.com/3", Scope: cache.ScopeExact},
+	}
+
+	groups := make([]group, len(prs))
+	for i := range groups {
+		groups[i].purge = i
+	}
+
+	var (
+		mu       sync.Mutex
+		applied  []string
+		inFlight int32
+		peak     int32
+	)
+	fn := Func(func(_ context.Context, _ *zap.Logger, pr *cache.PurgeRequest) error {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		applied = append(applied, pr.URL)
+		mu.Unlock()
+
+		switch pr.URL {
+		case "http://a.com/fail":
+			return errors.New("boom")
+		case "http://c.com/unverified":
+			return &VerifyError{}
+		default:
+			return nil
+		}
+	})
+
+	errs := fn.dispatch(context.Background(), zap.NewNop(), prs, groups, 8)
+
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.EqualError(t, errs[2], "boom")
+	assert.NoError(t, errs[3])
+	assert.Equal(t, errSkipped, errs[4])
+	assert.IsType(t, &VerifyError{}, errs[5])
+	assert.NoError(t, errs[6])
+
+	// hosts were purged concurrently, up to one purge per host at a time, each
+	// in order
+	assert.Greater(t, peak, int32(1))
+	assert.LessOrEqual(t, peak, int32(3))
+	assert.Len(t, applied, 6)
+	assert.Less(t, indexOf(applied, "http://a.com/1"), indexOf(applied, "http://a.com/fail"))
+	assert.Less(t, indexOf(applied, "http://b.com/1"), indexOf(applied, "http://b.com/2"))
+	assert.Less(t, indexOf(applied, "http://c.com/unverified"), indexOf(applied, "http://c.com/3"))
+
+	// a single worker purges one host at a time
+	peak = 0
+	applied = nil
+	fn.dispatch(context.Background(), zap.NewNop(), prs, groups, 1)
+	assert.Equal(t, int32(1), peak)
+}
+
+func indexOf(list []string, s string) int {
+	for i := range list {
+		if list[i] == s {
+			return i
+		}
+	}
+
+	return -1
+}
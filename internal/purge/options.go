@@ -0,0 +1,55 @@
+package purge
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// TokenHeader denotes the header which carries the shared secret token set via
+// WithToken.
+const TokenHeader = "X-Purgery-Token"
+
+// Option denotes the set of options New accepts.
+type Option func(*options)
+
+type options struct {
+	tls    *tls.Config
+	header http.Header
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		header: make(http.Header),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithTLS configures Funcs to connect to the target over TLS, using the given
+// configuration.
+//
+// When cfg doesn't specify a ServerName, the host of the target's TCP address
+// is used for SNI and verification.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tls = cfg
+	}
+}
+
+// WithHeader configures Funcs to send the given header along with each purge
+// request.
+func WithHeader(name, value string) Option {
+	return func(o *options) {
+		o.header.Set(name, value)
+	}
+}
+
+// WithToken configures Funcs to send the given shared secret token, via
+// TokenHeader, along with each purge request.
+func WithToken(token string) Option {
+	return WithHeader(TokenHeader, token)
+}
@@ -2,9 +2,12 @@ package purge
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -15,11 +18,24 @@ import (
 )
 
 // Func is the set of functions capable of purging the cache.
-type Func func(ctx context.Context, logger *zap.Logger, varnishAddr string) bool
+type Func func(ctx context.Context, logger *zap.Logger, pr *cache.PurgeRequest) error
 
 // Run runs the Func until the given Context is cancelled.
-func (fn Func) Run(ctx context.Context, logger *zap.Logger, cache *cache.Cache) {
-	for ok := true; ; ok = fn.tick(ctx, logger, cache) {
+//
+// The purge stream is consumed via a Consumer, which writes its checkpoint
+// back after up to cache.DefaultFlushCount entries or
+// cache.DefaultFlushInterval, and once more when Run returns.
+func (fn Func) Run(ctx context.Context, logger *zap.Logger, c *cache.Cache, opts ...RunOption) {
+	o := newRunOptions(opts)
+
+	cs := c.NewConsumer(cache.DefaultFlushCount, cache.DefaultFlushInterval)
+	defer cs.Close(logger)
+
+	// unprobed holds the number of entries the backlog was last probed at,
+	// which are consumed before it's probed again
+	var unprobed int
+
+	for backlogged, ok := true, true; ; {
 		// after each error sleep for a bit
 		if !ok {
 			const errorSleep = time.Millisecond << 6
@@ -32,41 +48,172 @@ func (fn Func) Run(ctx context.Context, logger *zap.Logger, cache *cache.Cache)
 
 			break
 		}
+
+		// backlogs build up while instances are down or fall behind, in which
+		// case the last tick read a full batch
+		if backlogged && o.collapseAbove > 0 && unprobed <= 0 {
+			var collapsed bool
+			if unprobed, collapsed, ok = fn.collapse(ctx, logger, c, cs, o.collapseAbove); collapsed || !ok {
+				continue
+			}
+		}
+
+		if backlogged, ok = fn.tick(ctx, logger, c, cs, o); backlogged {
+			unprobed -= batchSize
+		} else {
+			unprobed = 0 // caught up
+		}
 	}
 }
 
-func (fn Func) tick(ctx context.Context, logger *zap.Logger, cache *cache.Cache) (ok bool) {
-	var checkpoint, url string
-	switch checkpoint, url, ok = cache.Next(logger); {
-	case !ok:
-		break
-	case url == "":
-		break
-	case !common.IsValidURL(url):
-		logger.Warn("invalid url fetched; dropping ...", log.URL(url))
-
-		ok = cache.Store(logger, checkpoint)
-	default:
-		ok = fn(ctx, logger, url) &&
-			cache.Store(logger, checkpoint)
+// batchSize denotes the maximum number of entries each tick reads.
+const batchSize = 100
+
+// tick purges the next batch of entries. It reports whether the batch was a
+// full one.
+func (fn Func) tick(ctx context.Context, logger *zap.Logger, c *cache.Cache, cs *cache.Consumer, o *runOptions) (full, ok bool) {
+	checkpoint, batch, ok := cs.Next(logger, batchSize)
+	if !ok || checkpoint == "" {
+		return false, ok
+	}
+	full = len(batch) == batchSize
+
+	// results holds the outcomes of the batch's entries, which are recorded
+	// together
+	results := make([]cache.Result, 0, len(batch))
+
+	// valid holds the indices of the entries which are to be purged
+	valid := make([]int, 0, len(batch))
+	for i := range batch {
+		switch e := &batch[i]; {
+		case !common.IsValidURL(e.URL):
+			logger.Warn("invalid url fetched; dropping ...", log.URL(e.URL))
+
+			results = append(results, cache.Result{Entry: e.ID, URL: e.URL, Type: cache.EventDropped})
+		case !cache.IsValidScope(e.Scope):
+			logger.Warn("invalid scope fetched; dropping ...",
+				log.URL(e.URL),
+				zap.String("scope", e.Scope))
+
+			results = append(results, cache.Result{Entry: e.ID, URL: e.URL, Type: cache.EventDropped})
+		default:
+			valid = append(valid, i)
+		}
+	}
+
+	prs := make([]*cache.PurgeRequest, len(valid))
+	for i, j := range valid {
+		prs[i] = &batch[j].PurgeRequest
 	}
 
-	return
+	groups := coalesce(prs)
+	if n := len(prs) - len(groups); n > 0 {
+		coalescedPurges.Add(int64(n))
+
+		logger.Info("coalesced purges.",
+			zap.Int("entries", len(prs)),
+			zap.Int("purges", len(groups)))
+	}
+
+	// failed holds the index of the earliest entry whose purge failed
+	failed := len(batch)
+	for gi, err := range fn.dispatch(ctx, logger, prs, groups, o.concurrency) {
+		var (
+			ve  *VerifyError
+			ire invalidRequestError
+			typ string
+		)
+
+		switch {
+		case err == nil:
+			typ = cache.EventApplied
+		case errors.As(err, &ve):
+			// the purge went through; there's no point in holding up the
+			// entries that follow it.
+			typ = cache.EventUnverified
+		case errors.As(err, &ire):
+			// retrying the purge would fail just the same; it'd stall the
+			// entries which follow it.
+			typ = cache.EventDropped
+		case errors.Is(err, errSkipped):
+			// the purge is retried along with the one that failed
+		default:
+			typ = cache.EventFailed
+		}
+
+		g := &groups[gi]
+		for _, i := range append([]int{g.purge}, g.covered...) {
+			e := &batch[valid[i]]
+
+			if typ != "" {
+				results = append(results, cache.Result{Entry: e.ID, URL: e.URL, Type: typ, Err: err})
+			}
+
+			if (typ == "" || typ == cache.EventFailed) && valid[i] < failed {
+				failed = valid[i]
+			}
+		}
+	}
+
+	c.RecordResults(logger, results)
+
+	if failed == len(batch) {
+		return full, cs.Store(logger, checkpoint)
+	}
+
+	// the checkpoint only moves past the contiguous entries which precede the
+	// earliest one that failed, so that it's retried along with those that
+	// follow it.
+	if failed > 0 {
+		cs.Store(logger, batch[failed-1].ID)
+	}
+
+	return full, false
 }
 
+// unixPrefix prefixes addresses which denote Unix domain sockets.
+const unixPrefix = "unix:"
+
 // New initializes and returns a Func which purges against the given Varnish
 // address.
-func New(addr string) Func {
+//
+// Addresses of the form unix:<path> denote Unix domain sockets. Requests sent
+// over them still carry the Host of the URL being purged.
+func New(addr string, opts ...Option) Func {
+	o := newOptions(opts)
+
+	return newPurgeFunc(newClient(addr, o), o.header)
+}
+
+// newClient returns an http.Client which sends all requests to the given
+// address, regardless of the URLs they're for.
+func newClient(addr string, o *options) *http.Client {
+	network := "tcp"
+	if strings.HasPrefix(addr, unixPrefix) {
+		network, addr = "unix", addr[len(unixPrefix):]
+	}
+
 	dialer := &net.Dialer{
 		Timeout:   5 * time.Second,
 		KeepAlive: 5 * time.Second,
 	}
 
-	client := http.Client{
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	if o.tls != nil {
+		cfg := o.tls.Clone()
+		if cfg.ServerName == "" && network == "tcp" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+
+		dial = dialTLS(dial, cfg)
+	}
+
+	return &http.Client{
 		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
-				return dialer.DialContext(ctx, network, addr)
-			},
+			DialContext:           dial,
 			ForceAttemptHTTP2:     true,
 			MaxIdleConns:          100,
 			IdleConnTimeout:       90 * time.Second,
@@ -74,25 +221,57 @@ func New(addr string) Func {
 			ExpectContinueTimeout: 1 * time.Second,
 		},
 	}
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialTLS wraps the connections dial returns with TLS. Since the URLs purge
+// requests are for are plain HTTP ones, the Transport itself never
+// negotiates TLS.
+func dialTLS(dial dialFunc, cfg *tls.Config) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+
+			return nil, err
+		}
 
-	return newPurgeFunc(&client)
+		return tc, nil
+	}
 }
 
-func newPurgeFunc(client *http.Client) Func {
-	return func(ctx context.Context, logger *zap.Logger, url string) (ok bool) {
-		logger = logger.With(log.URL(url))
+func newPurgeFunc(client *http.Client, header http.Header) Func {
+	return func(ctx context.Context, logger *zap.Logger, pr *cache.PurgeRequest) (err error) {
+		logger = logger.With(log.URL(pr.URL), zap.String("scope", pr.Scope))
 		logger.Info("purging ...")
 
-		req, err := http.NewRequestWithContext(ctx, "BAN", url, nil)
-		if err != nil {
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(ctx, "BAN", pr.URL, nil); err != nil {
 			logger.Warn("failed creating purge request.",
 				zap.Error(err))
 
-			return
+			return invalidRequestError{err}
 		}
 
-		res, err := client.Do(req)
-		if err != nil {
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		if err = setHeaders(req.Header, pr); err != nil {
+			logger.Warn("failed describing purge request.",
+				zap.Error(err))
+
+			return invalidRequestError{err}
+		}
+
+		var res *http.Response
+		if res, err = client.Do(req); err != nil {
 			logger.Warn("failed retrieving purge response.",
 				zap.Error(err))
 
@@ -100,11 +279,13 @@ func newPurgeFunc(client *http.Client) Func {
 		}
 		defer res.Body.Close()
 
-		switch ok = res.StatusCode == http.StatusOK; ok {
+		switch res.StatusCode {
 		default:
+			err = errInvalidStatusCode(res.StatusCode)
+
 			logger.Warn("received wrong purge status code.",
 				zap.Int("code", res.StatusCode))
-		case true:
+		case http.StatusOK:
 			logger.Debug("purged.")
 		}
 
@@ -0,0 +1,190 @@
+package purge
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+func TestNewOverUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "varnish.sock")
+
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	type request struct {
+		method, host, uri string
+	}
+	requests := make(chan request, 1)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- request{r.Method, r.Host, r.RequestURI}
+
+			if r.Host == "broken.example.com" {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}),
+	}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	fn := New("unix:" + path)
+
+	require.NoError(t, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/some/path"}))
+	assert.Equal(t, request{"BAN", "example.com", "/some/path"}, <-requests)
+
+	err = fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://broken.example.com/"})
+	assert.Equal(t, errInvalidStatusCode(http.StatusForbidden), err)
+	<-requests
+}
+
+func TestNewWithTLSAndToken(t *testing.T) {
+	tokens := make(chan string, 1)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens <- r.Header.Get(TokenHeader)
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	fn := New(srv.Listener.Addr().String(),
+		WithTLS(&tls.Config{
+			RootCAs:    pool,
+			ServerName: "example.com",
+		}),
+		WithToken("secret"),
+	)
+
+	require.NoError(t, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+	assert.Equal(t, "secret", <-tokens)
+
+	// the target's certificate isn't valid for other names
+	fn = New(srv.Listener.Addr().String(),
+		WithTLS(&tls.Config{
+			RootCAs:    pool,
+			ServerName: "other.com",
+		}),
+	)
+
+	assert.Error(t, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+}
+
+func TestTick(t *testing.T) {
+	exact := func(url string) *cache.PurgeRequest {
+		return &cache.PurgeRequest{URL: url, Scope: cache.ScopeExact}
+	}
+
+	conn := newStreamConn(
+		exact("http://a.com/1"),
+		exact("http://b.com/1"),
+		&cache.PurgeRequest{URL: "http://c.com/", Scope: cache.ScopeHost},
+		exact("http://b.com/fail"),
+		exact("http://c.com/1"), // covered by 1-3
+		exact("http://b.com/2"), // held back, since 1-4 fails
+		exact("http://a.com/2"),
+	)
+	c := conn.newCache()
+
+	var (
+		mu     sync.Mutex
+		purged []string
+		broken = true
+	)
+	fn := Func(func(_ context.Context, _ *zap.Logger, pr *cache.PurgeRequest) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		purged = append(purged, pr.URL)
+		if broken && pr.URL == "http://b.com/fail" {
+			return errors.New("boom")
+		}
+
+		return nil
+	})
+
+	logger := zap.NewNop()
+	o := newRunOptions([]RunOption{WithConcurrency(4)})
+
+	cs := c.NewConsumer(1, time.Hour)
+	defer cs.Close(logger)
+
+	// the checkpoint only moves past the entries which precede the failed one
+	full, ok := fn.tick(context.Background(), logger, c, cs, o)
+	assert.False(t, full)
+	assert.False(t, ok)
+	assert.Equal(t, "1-3", conn.cp)
+
+	assert.ElementsMatch(t, []string{
+		"http://a.com/1", "http://b.com/1", "http://c.com/", "http://b.com/fail", "http://a.com/2",
+	}, purged)
+
+	// results are recorded for every entry of a coalesced group, but not for
+	// those which are held back
+	for id, typ := range map[string]string{
+		"1-1": cache.EventApplied,
+		"1-2": cache.EventApplied,
+		"1-3": cache.EventApplied,
+		"1-4": cache.EventFailed,
+		"1-5": cache.EventApplied,
+		"1-6": "",
+		"1-7": cache.EventApplied,
+	} {
+		assert.Equal(t, typ, conn.result(id), id)
+	}
+
+	// the failed entry is retried, along with every one that follows it
+	purged, broken = nil, false
+
+	_, ok = fn.tick(context.Background(), logger, c, cs, o)
+	assert.True(t, ok)
+	assert.Equal(t, "1-7", conn.cp)
+
+	assert.ElementsMatch(t, []string{
+		"http://b.com/fail", "http://c.com/1", "http://b.com/2", "http://a.com/2",
+	}, purged)
+	assert.Less(t, indexOf(purged, "http://b.com/fail"), indexOf(purged, "http://b.com/2"))
+}
+
+func TestTickInvalidRequest(t *testing.T) {
+	conn := newStreamConn(
+		&cache.PurgeRequest{URL: "http://a.com/", Scope: cache.ScopeHost},
+		&cache.PurgeRequest{URL: "http://b.com/", Scope: cache.ScopeHost},
+	)
+	c := conn.newCache()
+
+	fn := Func(func(_ context.Context, _ *zap.Logger, pr *cache.PurgeRequest) error {
+		if pr.URL == "http://a.com/" {
+			return invalidRequestError{errNoPattern}
+		}
+
+		return nil
+	})
+
+	logger := zap.NewNop()
+	cs := c.NewConsumer(1, time.Hour)
+	defer cs.Close(logger)
+
+	// purges which can't be described are dropped, rather than retried
+	_, ok := fn.tick(context.Background(), logger, c, cs, newRunOptions(nil))
+	assert.True(t, ok)
+	assert.Equal(t, "1-2", conn.cp)
+	assert.Equal(t, cache.EventDropped, conn.result("1-1"))
+	assert.Equal(t, cache.EventApplied, conn.result("1-2"))
+}
@@ -0,0 +1,103 @@
+package purge
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+// The set of headers purge requests carry, as expected by the VCL the vcl
+// package renders.
+const (
+	// ScopeHeader carries the scope of the purge.
+	ScopeHeader = "X-Purgery-Scope"
+
+	// PatternHeader carries the regular expression prefix- and regex-scoped
+	// purges match URLs against.
+	PatternHeader = "X-Purgery-Pattern"
+
+	// KeysHeader carries the space-separated keys of xkey-scoped purges.
+	KeysHeader = "X-Purgery-Keys"
+
+	// SoftHeader is set on soft purges.
+	SoftHeader = "X-Purgery-Soft"
+
+	// TraceParentHeader carries the W3C trace context purges were requested
+	// under.
+	TraceParentHeader = "Traceparent"
+)
+
+// Pattern returns the regular expression the given prefix- or regex-scoped
+// purge request matches URLs against.
+func Pattern(pr *cache.PurgeRequest) (string, error) {
+	switch pr.Scope {
+	case cache.ScopePrefix:
+		u, err := url.Parse(pr.URL)
+		if err != nil {
+			return "", err
+		}
+
+		return "^" + regexp.QuoteMeta(u.RequestURI()), nil
+	case cache.ScopeRegex:
+		if pr.Pattern == "" {
+			return "", errNoPattern
+		}
+
+		return pr.Pattern, nil
+	default:
+		return "", fmt.Errorf("purge: %s-scoped purges carry no pattern", pr.Scope)
+	}
+}
+
+var errNoPattern = errors.New("purge: regex-scoped purge without a pattern")
+
+// invalidRequestError wraps the errors describing purge requests results in.
+// Since retrying the purges doesn't change the outcome, they're dropped.
+type invalidRequestError struct {
+	err error
+}
+
+// Error implements error for invalidRequestError.
+func (err invalidRequestError) Error() string {
+	return err.err.Error()
+}
+
+// Unwrap implements errors.Unwrap for invalidRequestError.
+func (err invalidRequestError) Unwrap() error {
+	return err.err
+}
+
+// setHeaders sets the headers which describe the given purge request.
+func setHeaders(h http.Header, pr *cache.PurgeRequest) error {
+	scope := pr.Scope
+	if scope == "" {
+		scope = cache.ScopeHost
+	}
+	h.Set(ScopeHeader, scope)
+
+	switch scope {
+	case cache.ScopePrefix, cache.ScopeRegex:
+		pattern, err := Pattern(pr)
+		if err != nil {
+			return err
+		}
+		h.Set(PatternHeader, pattern)
+	case cache.ScopeXKey:
+		h.Set(KeysHeader, strings.Join(pr.Keys, " "))
+	}
+
+	if pr.Soft {
+		h.Set(SoftHeader, "1")
+	}
+
+	if pr.Trace != "" {
+		h.Set(TraceParentHeader, pr.Trace)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package purge
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+func TestSetHeaders(t *testing.T) {
+	cases := []struct {
+		pr  cache.PurgeRequest
+		exp http.Header
+		err bool
+	}{
+		0: {
+			pr: cache.PurgeRequest{URL: "http://example.com/"},
+			exp: http.Header{
+				ScopeHeader: {cache.ScopeHost},
+			},
+		},
+		1: {
+			pr: cache.PurgeRequest{URL: "http://example.com/a?b=c", Scope: cache.ScopeExact, Soft: true},
+			exp: http.Header{
+				ScopeHeader: {cache.ScopeExact},
+				SoftHeader:  {"1"},
+			},
+		},
+		2: {
+			pr: cache.PurgeRequest{URL: "http://example.com/a.b/c?d", Scope: cache.ScopePrefix},
+			exp: http.Header{
+				ScopeHeader:   {cache.ScopePrefix},
+				PatternHeader: {`^/a\.b/c\?d`},
+			},
+		},
+		3: {
+			pr: cache.PurgeRequest{URL: "http://example.com/", Scope: cache.ScopeRegex, Pattern: `\.css$`},
+			exp: http.Header{
+				ScopeHeader:   {cache.ScopeRegex},
+				PatternHeader: {`\.css$`},
+			},
+		},
+		4: {
+			pr:  cache.PurgeRequest{URL: "http://example.com/", Scope: cache.ScopeRegex},
+			err: true,
+		},
+		5: {
+			pr: cache.PurgeRequest{URL: "http://example.com/", Scope: cache.ScopeXKey, Keys: []string{"a", "b"}},
+			exp: http.Header{
+				ScopeHeader: {cache.ScopeXKey},
+				KeysHeader:  {"a b"},
+			},
+		},
+		6: {
+			pr: cache.PurgeRequest{URL: "http://example.com/", Trace: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+			exp: http.Header{
+				ScopeHeader:       {cache.ScopeHost},
+				TraceParentHeader: {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+			},
+		},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			h := http.Header{}
+
+			if err := setHeaders(h, &kase.pr); kase.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, kase.exp, h)
+			}
+		})
+	}
+}
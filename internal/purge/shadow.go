@@ -0,0 +1,77 @@
+package purge
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/log"
+	"github.com/soupedup/purgery/internal/metrics"
+)
+
+var (
+	dryRunPurges   = metrics.Counter("dry_run_purges")
+	mirrorPurges   = metrics.Counter("mirror_purges")
+	mirrorFailures = metrics.Counter("mirror_failures")
+)
+
+// DryRun wraps fn so that purges are logged and counted rather than applied.
+// fn is never called and the returned Func never fails, which lets instances
+// consume the purge stream, and advance their checkpoint, without contacting
+// their target.
+func (fn Func) DryRun() Func {
+	return func(_ context.Context, logger *zap.Logger, pr *cache.PurgeRequest) error {
+		dryRunPurges.Add(1)
+
+		logger.Info("would purge.",
+			log.URL(pr.URL),
+			zap.String("scope", pr.Scope),
+			zap.String("pattern", pr.Pattern),
+			zap.Strings("keys", pr.Keys),
+			zap.Bool("soft", pr.Soft))
+
+		return nil
+	}
+}
+
+// mirrorTimeout denotes for how long mirrored purges may take.
+const mirrorTimeout = 5 * time.Second
+
+// Mirror wraps fn so that each purge fn applies is also sent to the given
+// secondary Func. The failures of secondary are logged and counted but never
+// affect the outcome of the purge.
+//
+// Purges are mirrored in the background, for up to mirrorTimeout, so that
+// slow secondaries don't hold up purges.
+func (fn Func) Mirror(secondary Func) Func {
+	return fn.mirror(secondary, mirrorTimeout)
+}
+
+func (fn Func) mirror(secondary Func, timeout time.Duration) Func {
+	return func(ctx context.Context, logger *zap.Logger, pr *cache.PurgeRequest) error {
+		if err := fn(ctx, logger, pr); err != nil {
+			return err
+		}
+
+		mirrorPurges.Add(1)
+
+		go func() {
+			// the purge may well have returned, along with its Context, by
+			// the time the secondary is done
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			if err := secondary(ctx, logger.Named("mirror"), pr); err != nil {
+				mirrorFailures.Add(1)
+
+				logger.Warn("failed mirroring purge; ignoring ...",
+					log.URL(pr.URL),
+					zap.Error(err))
+			}
+		}()
+
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+// recorder returns a Func which records the URLs it's called with and fails
+// with err.
+func recorder(urls *[]string, err error) Func {
+	return func(_ context.Context, _ *zap.Logger, pr *cache.PurgeRequest) error {
+		*urls = append(*urls, pr.URL)
+
+		return err
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var called []string
+	fn := recorder(&called, errors.New("failed")).DryRun()
+
+	before := dryRunPurges.Value()
+	assert.NoError(t, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+	assert.Empty(t, called)
+	assert.Equal(t, before+1, dryRunPurges.Value())
+}
+
+func TestMirror(t *testing.T) {
+	var primary []string
+
+	mirrored := make(chan string, 1)
+	secondary := Func(func(_ context.Context, _ *zap.Logger, pr *cache.PurgeRequest) error {
+		mirrored <- pr.URL
+
+		return errors.New("secondary failed")
+	})
+	fn := recorder(&primary, nil).Mirror(secondary)
+
+	before := mirrorFailures.Value()
+	assert.NoError(t, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+	assert.Equal(t, []string{"http://example.com/"}, primary)
+	assert.Equal(t, "http://example.com/", <-mirrored)
+	assert.Eventually(t, func() bool {
+		return mirrorFailures.Value() == before+1
+	}, time.Second, time.Millisecond)
+}
+
+func TestMirrorDoesntWait(t *testing.T) {
+	var primary []string
+
+	// the secondary hangs until its Context expires
+	expired := make(chan error, 1)
+	secondary := Func(func(ctx context.Context, _ *zap.Logger, _ *cache.PurgeRequest) error {
+		<-ctx.Done()
+		expired <- ctx.Err()
+
+		return ctx.Err()
+	})
+	fn := recorder(&primary, nil).mirror(secondary, 50*time.Millisecond)
+
+	// the secondary outlives the purge, along with its Context
+	ctx, cancel := context.WithCancel(context.Background())
+
+	started := time.Now()
+	assert.NoError(t, fn(ctx, zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+	cancel()
+	assert.Less(t, time.Since(started), 50*time.Millisecond)
+
+	assert.Equal(t, context.DeadlineExceeded, <-expired)
+}
+
+func TestMirrorSkipsFailedPurges(t *testing.T) {
+	var primary, secondary []string
+
+	errPrimary := errors.New("primary failed")
+	fn := recorder(&primary, errPrimary).Mirror(recorder(&secondary, nil))
+
+	assert.Equal(t, errPrimary, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+	assert.Len(t, primary, 1)
+	assert.Empty(t, secondary)
+}
@@ -0,0 +1,152 @@
+package purge
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+// streamConn implements a redis.Conn which serves a purge stream, along with a
+// checkpoint, and records the results it's sent and the entries it's asked to
+// quarantine.
+//
+// Entries are identified by their position in the stream; the first one is
+// 1-1.
+type streamConn struct {
+	mu      sync.Mutex
+	entries []*cache.PurgeRequest
+	cp      string              // as stored
+	results []map[string]string // as recorded
+
+	quarantined []string // IDs of the entries moved to the quarantine stream
+}
+
+func newStreamConn(prs ...*cache.PurgeRequest) *streamConn {
+	return &streamConn{
+		entries: prs,
+		cp:      "1-0",
+	}
+}
+
+// newCache returns a Cache backed by the streamConn.
+func (c *streamConn) newCache() *cache.Cache {
+	return cache.New("a", &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return c, nil
+		},
+	})
+}
+
+// result returns the type of the result recorded for the given entry, or an
+// empty string in case none was.
+func (c *streamConn) result(id string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, r := range c.results {
+		if r["entry"] == id {
+			return r["type"]
+		}
+	}
+
+	return ""
+}
+
+func (c *streamConn) Close() error { return nil }
+func (c *streamConn) Err() error   { return nil }
+
+func (c *streamConn) Flush() error                  { return errors.New("not implemented") }
+func (c *streamConn) Receive() (interface{}, error) { return nil, errors.New("not implemented") }
+
+func (c *streamConn) Send(cmd string, args ...interface{}) error {
+	if cmd != "XADD" {
+		return errors.New("unexpected command: " + cmd)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// results, MAXLEN, ~, 10000, * and then the fields
+	fields := make(map[string]string)
+	for i := 5; i+1 < len(args); i += 2 {
+		fields[args[i].(string)] = args[i+1].(string)
+	}
+	c.results = append(c.results, fields)
+
+	return nil
+}
+
+func (c *streamConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch cmd {
+	case "":
+		return nil, nil // pipelines, and connection pools, flush via empty commands
+	case "EVALSHA":
+		// scripts are told apart by the keys they're passed
+		keys := args[1].(int)
+
+		switch {
+		case keys == 1 && args[2] == "purgery:purge":
+			// the backlog probe
+			return int64(len(c.after(args[3].(string), args[4].(int)))), nil
+		case keys == 2 && args[2] == "purgery:purge":
+			// the quarantine
+			c.quarantined = append(c.quarantined, args[4].(string))
+
+			return int64(1), nil
+		case keys == 3 && args[2] == "purgery:purge":
+			return []interface{}{[]byte(c.cp), int64(0)}, nil
+		case keys == 3 && strings.HasPrefix(args[2].(string), "purgery:checkpoints:"):
+			c.cp = args[5].(string)
+
+			return []interface{}{int64(1), []byte("")}, nil
+		}
+	case "XREAD":
+		// COUNT, count, BLOCK, ms, STREAMS, stream, id
+		if entries := c.after(args[6].(string), args[1].(int)); len(entries) > 0 {
+			return []interface{}{
+				[]interface{}{[]byte("purgery:purge"), entries},
+			}, nil
+		}
+
+		return nil, nil
+	case "XRANGE":
+		// stream, (id, +, COUNT, count
+		return c.after(strings.TrimPrefix(args[1].(string), "("), args[4].(int)), nil
+	}
+
+	return nil, errors.New("unexpected command: " + cmd)
+}
+
+// after returns up to count of the entries which follow the given ID, in their
+// wire format.
+func (c *streamConn) after(id string, count int) (entries []interface{}) {
+	seq, _ := strconv.Atoi(strings.TrimPrefix(id, "1-"))
+
+	for i := seq; i < len(c.entries) && len(entries) < count; i++ {
+		pr := c.entries[i]
+
+		fields := []interface{}{
+			[]byte("url"), []byte(pr.URL),
+			[]byte("v"), []byte("1"),
+			[]byte("scope"), []byte(pr.Scope),
+		}
+		if len(pr.Keys) > 0 {
+			fields = append(fields, []byte("keys"), []byte(strings.Join(pr.Keys, " ")))
+		}
+
+		entries = append(entries, []interface{}{
+			[]byte("1-" + strconv.Itoa(i+1)),
+			fields,
+		})
+	}
+
+	return
+}
@@ -0,0 +1,145 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/metrics"
+)
+
+var (
+	verifyProbes   = metrics.Counter("verify_probes")
+	verifyRetries  = metrics.Counter("verify_retries")
+	verifyFailures = metrics.Counter("verify_failures")
+)
+
+// Verifier implements the verification of purges, by requesting the purged
+// URLs through the cache target and checking a header of the responses.
+//
+// Instances of Verifier are safe for concurrent use.
+type Verifier struct {
+	client  *http.Client
+	header  string
+	expect  string
+	changed bool
+	retries int
+}
+
+// NewVerifier returns a Verifier which sends its probes to the given address
+// (see New) and checks the given header of their responses.
+//
+// In case changed is set, purges are verified when the value of the header
+// differs from the one it had before the purge (as is the case for Age or
+// ETag). Otherwise, they're verified when the value of the header contains
+// expect (i.e. an x-cache header which contains miss).
+//
+// Purges which fail verification are retried up to retries times.
+func NewVerifier(addr, header, expect string, changed bool, retries int, opts ...Option) *Verifier {
+	return &Verifier{
+		client:  newClient(addr, newOptions(opts)),
+		header:  header,
+		expect:  strings.ToLower(expect),
+		changed: changed,
+		retries: retries,
+	}
+}
+
+// VerifyError is returned by Funcs wrapped via Verify when a purge which
+// succeeded failed verification.
+type VerifyError struct {
+	Header string
+	Value  string
+}
+
+// Error implements error for VerifyError.
+func (err *VerifyError) Error() string {
+	return fmt.Sprintf("purge: verification failed (%s: %q)", err.Header, err.Value)
+}
+
+// Verify wraps fn so that each successful purge is verified, and retried
+// when verification fails.
+func (fn Func) Verify(v *Verifier) Func {
+	return func(ctx context.Context, logger *zap.Logger, pr *cache.PurgeRequest) error {
+		var before string
+		if v.changed {
+			before, _ = v.probe(ctx, logger, pr.URL)
+		}
+
+		for attempt := 0; ; attempt++ {
+			if err := fn(ctx, logger, pr); err != nil {
+				return err
+			}
+
+			got, err := v.probe(ctx, logger, pr.URL)
+			if err == nil && v.satisfied(before, got) {
+				logger.Debug("verified.")
+
+				return nil
+			}
+
+			if attempt >= v.retries {
+				verifyFailures.Add(1)
+
+				logger.Warn("failed verifying purge.",
+					zap.String("header", v.header),
+					zap.String("value", got),
+					zap.NamedError("probe_error", err))
+
+				return &VerifyError{Header: v.header, Value: got}
+			}
+
+			verifyRetries.Add(1)
+			logger.Info("purge unverified; retrying ...",
+				zap.Int("attempt", attempt+1))
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(verifyBackoff << attempt):
+			}
+		}
+	}
+}
+
+// verifyBackoff denotes the delay before the first retry of an unverified
+// purge; subsequent retries back off exponentially.
+const verifyBackoff = 100 * time.Millisecond
+
+func (v *Verifier) satisfied(before, got string) bool {
+	if v.changed {
+		// there's nothing to compare against when the header was missing
+		return before == "" || before != got
+	}
+
+	return strings.Contains(strings.ToLower(got), v.expect)
+}
+
+// probe requests the given URL and returns the value of the Verifier's header.
+func (v *Verifier) probe(ctx context.Context, logger *zap.Logger, url string) (string, error) {
+	verifyProbes.Add(1)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		logger.Debug("failed probing.",
+			zap.Error(err))
+
+		return "", err
+	}
+	defer res.Body.Close()
+
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	return res.Header.Get(v.header), nil
+}
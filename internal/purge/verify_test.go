@@ -0,0 +1,89 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+// newVarnish returns a server which mimics a cache which reports whether each
+// GET was a hit via the x-cache header, and how often it's been purged via the
+// etag one. The first missAfter purges it receives don't take.
+func newVarnish(t *testing.T, missAfter int32) (srv *httptest.Server, purges *int32) {
+	t.Helper()
+
+	purges = new(int32)
+	var cached int32 = 1
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "BAN" {
+			if atomic.AddInt32(purges, 1) > missAfter {
+				atomic.StoreInt32(&cached, 0)
+			}
+
+			return
+		}
+
+		w.Header().Set("ETag", strconv.Itoa(int(atomic.LoadInt32(purges))))
+		if atomic.SwapInt32(&cached, 1) == 1 {
+			w.Header().Set("X-Cache", "hit cached")
+		} else {
+			w.Header().Set("X-Cache", "miss cached")
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return
+}
+
+func TestVerify(t *testing.T) {
+	srv, purges := newVarnish(t, 0)
+	addr := srv.Listener.Addr().String()
+
+	fn := New(addr).Verify(NewVerifier(addr, "x-cache", "MISS", false, 0))
+
+	assert.NoError(t, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+	assert.EqualValues(t, 1, atomic.LoadInt32(purges))
+}
+
+func TestVerifyChanged(t *testing.T) {
+	srv, _ := newVarnish(t, 0)
+	addr := srv.Listener.Addr().String()
+
+	fn := New(addr).Verify(NewVerifier(addr, "etag", "", true, 0))
+
+	assert.NoError(t, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+}
+
+func TestVerifyRetries(t *testing.T) {
+	srv, purges := newVarnish(t, 2)
+	addr := srv.Listener.Addr().String()
+
+	fn := New(addr).Verify(NewVerifier(addr, "x-cache", "miss", false, 2))
+
+	assert.NoError(t, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+	assert.EqualValues(t, 3, atomic.LoadInt32(purges))
+}
+
+func TestVerifyFails(t *testing.T) {
+	srv, purges := newVarnish(t, 10)
+	addr := srv.Listener.Addr().String()
+
+	fn := New(addr).Verify(NewVerifier(addr, "x-cache", "miss", false, 1))
+
+	err := fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"})
+
+	var ve *VerifyError
+	assert.True(t, errors.As(err, &ve))
+	assert.Equal(t, &VerifyError{Header: "x-cache", Value: "hit cached"}, ve)
+	assert.EqualValues(t, 2, atomic.LoadInt32(purges))
+}
@@ -0,0 +1,182 @@
+package purge
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/log"
+	"github.com/soupedup/purgery/internal/metrics"
+)
+
+var (
+	warmQueued   = metrics.Counter("warm_queued")
+	warmDropped  = metrics.Counter("warm_dropped")
+	warmRequests = metrics.Counter("warm_requests")
+	warmFailures = metrics.Counter("warm_failures")
+)
+
+// warmQueueLen denotes the number of warm jobs which may be pending at any
+// given time, per unit of concurrency.
+const warmQueueLen = 64
+
+// Warmer implements the warming of the cache after purges, by sending GET
+// requests for the purged URLs through the cache target.
+//
+// Instances of Warmer are safe for concurrent use.
+type Warmer struct {
+	client      *http.Client
+	paths       []string
+	concurrency int
+	jobs        chan warmJob
+}
+
+type warmJob struct {
+	logger *zap.Logger
+	url    string
+}
+
+// NewWarmer returns a Warmer which sends its requests to the given address
+// (see New) via up to concurrency requests at a time.
+//
+// In case paths is empty, the Warmer requests each purged URL. Otherwise, it
+// requests each of the paths on the host of each purged URL.
+func NewWarmer(addr string, paths []string, concurrency int, opts ...Option) *Warmer {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &Warmer{
+		client:      newClient(addr, newOptions(opts)),
+		paths:       paths,
+		concurrency: concurrency,
+		jobs:        make(chan warmJob, concurrency*warmQueueLen),
+	}
+}
+
+// Warm wraps fn so that the cache is warmed after each successful purge.
+//
+// Warming happens in the background and its outcome never affects the outcome
+// of the purge. Purges which happen while the Warmer's queue is full aren't
+// warmed.
+func (fn Func) Warm(w *Warmer) Func {
+	return func(ctx context.Context, logger *zap.Logger, pr *cache.PurgeRequest) error {
+		if err := fn(ctx, logger, pr); err != nil {
+			return err
+		}
+
+		select {
+		case w.jobs <- warmJob{logger, pr.URL}:
+			warmQueued.Add(1)
+		default:
+			warmDropped.Add(1)
+
+			logger.Warn("warm queue full; not warming.", log.URL(pr.URL))
+		}
+
+		return nil
+	}
+}
+
+// Run runs the Warmer until the given Context is cancelled.
+func (w *Warmer) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-w.jobs:
+					w.warm(ctx, job.logger, job.url)
+				}
+			}
+		}()
+	}
+}
+
+func (w *Warmer) warm(ctx context.Context, logger *zap.Logger, rawurl string) {
+	urls := []string{rawurl}
+
+	if len(w.paths) > 0 {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return
+		}
+
+		urls = urls[:0]
+		for _, p := range w.paths {
+			ref, err := url.Parse(p)
+			if err != nil {
+				continue
+			}
+
+			urls = append(urls, u.ResolveReference(ref).String())
+		}
+	}
+
+	for _, u := range urls {
+		w.get(ctx, logger.With(log.URL(u)), u)
+	}
+}
+
+// warmTimeout denotes the maximum duration of warm requests.
+const warmTimeout = 30 * time.Second
+
+func (w *Warmer) get(ctx context.Context, logger *zap.Logger, url string) {
+	logger.Debug("warming ...")
+	warmRequests.Add(1)
+
+	ctx, cancel := context.WithTimeout(ctx, warmTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		warmFailures.Add(1)
+
+		logger.Warn("failed creating warm request.",
+			zap.Error(err))
+
+		return
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		warmFailures.Add(1)
+
+		logger.Warn("failed retrieving warm response.",
+			zap.Error(err))
+
+		return
+	}
+	defer res.Body.Close()
+
+	// the whole of the body should make it to the cache
+	_, err = io.Copy(io.Discard, res.Body)
+
+	switch {
+	case err != nil:
+		warmFailures.Add(1)
+
+		logger.Warn("failed reading warm response.",
+			zap.Error(err))
+	case res.StatusCode >= http.StatusBadRequest:
+		warmFailures.Add(1)
+
+		logger.Warn("received wrong warm status code.",
+			zap.Int("code", res.StatusCode))
+	default:
+		logger.Debug("warmed.")
+	}
+}
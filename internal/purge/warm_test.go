@@ -0,0 +1,78 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+func TestWarm(t *testing.T) {
+	gets := make(chan string, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets <- r.Host + r.RequestURI
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr := srv.Listener.Addr().String()
+	warmer := NewWarmer(addr, []string{"/", "/feed?page=1"}, 2)
+	go warmer.Run(ctx)
+
+	fn := New(addr).Warm(warmer)
+	require.NoError(t, fn(ctx, zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/article"}))
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case g := <-gets:
+			got[g] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for warm requests")
+		}
+	}
+
+	assert.Equal(t, map[string]bool{
+		"example.com/":            true,
+		"example.com/feed?page=1": true,
+	}, got)
+}
+
+func TestWarmSkipsFailedPurges(t *testing.T) {
+	warmer := NewWarmer("127.0.0.1:1", nil, 1)
+
+	errFailed := errors.New("failed")
+	fn := Func(func(context.Context, *zap.Logger, *cache.PurgeRequest) error {
+		return errFailed
+	}).Warm(warmer)
+
+	assert.Equal(t, errFailed, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+	assert.Len(t, warmer.jobs, 0)
+}
+
+func TestWarmNeverBlocks(t *testing.T) {
+	// a Warmer which isn't running can't drain its queue
+	warmer := NewWarmer("127.0.0.1:1", nil, 1)
+
+	fn := Func(func(context.Context, *zap.Logger, *cache.PurgeRequest) error {
+		return nil
+	}).Warm(warmer)
+
+	for i := 0; i < cap(warmer.jobs)+1; i++ {
+		require.NoError(t, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/"}))
+	}
+	assert.Len(t, warmer.jobs, cap(warmer.jobs))
+}
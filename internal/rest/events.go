@@ -0,0 +1,130 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+
+	"github.com/soupedup/purgery/internal/rest/internal/render"
+)
+
+// keepAlive denotes how often idle event streams receive a comment, so that
+// intermediaries don't consider them stale.
+const keepAlive = 15 * time.Second
+
+// events streams the event feed to the client via Server-Sent Events.
+//
+// The feed may be filtered by the host of the purged URLs (via the host query
+// parameter) and by the instance which produced an event (via the instance
+// query parameter). When filtering by instance, enqueue events are omitted as
+// they don't concern any particular instance.
+func (h *handler) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		render.InternalServerError(w)
+
+		return
+	}
+
+	query := r.URL.Query()
+	filter := eventFilter{
+		host:     strings.ToLower(query.Get("host")),
+		instance: query.Get("instance"),
+	}
+
+	var cursor cache.EventCursor
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		if cursor, err = cache.ParseEventCursor(id); err != nil {
+			render.UnprocessableEntity(w)
+
+			return
+		}
+	} else if cursor, ok = h.cache.LatestEventCursor(h.logger); !ok {
+		render.InternalServerError(w)
+
+		return
+	}
+
+	logger := h.logger.With(zap.String("cursor", cursor.String()))
+	logger.Debug("streaming events ...")
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	idleSince := time.Now()
+	for r.Context().Err() == nil {
+		events, ok := h.cache.Events(logger, cursor)
+		if !ok {
+			return
+		}
+
+		for _, evt := range events {
+			cursor = evt.Cursor
+
+			if !filter.matches(evt) {
+				continue
+			}
+
+			if err := writeEvent(w, evt); err != nil {
+				logger.Debug("failed writing event.", zap.Error(err))
+
+				return
+			}
+			idleSince = time.Now()
+		}
+
+		if time.Since(idleSince) >= keepAlive {
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			idleSince = time.Now()
+		}
+
+		flusher.Flush()
+	}
+
+	logger.Debug("stopped streaming events.")
+}
+
+func writeEvent(w http.ResponseWriter, evt cache.Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", evt.Cursor, evt.Type, data)
+
+	return err
+}
+
+type eventFilter struct {
+	host     string
+	instance string
+}
+
+func (f eventFilter) matches(evt cache.Event) bool {
+	if f.instance != "" && f.instance != evt.Instance {
+		return false
+	}
+
+	if f.host != "" {
+		u, err := url.Parse(evt.URL)
+		if err != nil || strings.ToLower(u.Hostname()) != f.host {
+			return false
+		}
+	}
+
+	return true
+}
@@ -3,18 +3,24 @@ package rest
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/julienschmidt/httprouter"
 	"go.uber.org/zap"
 
 	"github.com/soupedup/purgery/internal/cache"
 	"github.com/soupedup/purgery/internal/common"
+	"github.com/soupedup/purgery/internal/metrics"
 
 	"github.com/soupedup/purgery/internal/rest/internal/middleware"
 	"github.com/soupedup/purgery/internal/rest/internal/render"
 )
 
-func newHandler(logger *zap.Logger, cache *cache.Cache, apiKey string) http.Handler {
+func newHandler(logger *zap.Logger, cache *cache.Cache, opts *Options) http.Handler {
 	r := &handler{
 		Router: new(httprouter.Router),
 		logger: logger,
@@ -23,10 +29,33 @@ func newHandler(logger *zap.Logger, cache *cache.Cache, apiKey string) http.Hand
 
 	r.HandlerFunc(http.MethodGet, "/health", r.health)
 
+	apiKey := opts.APIKey
+
+	r.Handler(http.MethodGet, "/metrics", middleware.Auth(apiKey, metrics.Handler()))
+
 	purge := http.HandlerFunc(r.purge)
 	r.Handler(http.MethodPost, "/purge", middleware.Auth(apiKey, purge))
 
-	return middleware.Log(logger, r)
+	events := http.HandlerFunc(r.events)
+	r.Handler(http.MethodGet, "/events", middleware.Auth(apiKey, events))
+
+	scheduled := http.HandlerFunc(r.scheduled)
+	r.Handler(http.MethodGet, "/scheduled", middleware.Auth(apiKey, scheduled))
+
+	cancel := http.HandlerFunc(r.cancel)
+	r.Handler(http.MethodDelete, "/scheduled/:id", middleware.Auth(apiKey, cancel))
+
+	audit := http.HandlerFunc(r.audit)
+	r.Handler(http.MethodGet, "/audit", middleware.Auth(apiKey, audit))
+
+	purges := http.HandlerFunc(r.purges)
+	r.Handler(http.MethodGet, "/purges", middleware.Auth(apiKey, purges))
+
+	status := http.HandlerFunc(r.status)
+	r.Handler(http.MethodGet, "/purges/:id", middleware.Auth(apiKey, status))
+
+	return middleware.Log(logger,
+		middleware.Proxy(opts.TrustedProxies, r))
 }
 
 type handler struct {
@@ -43,23 +72,301 @@ func (h *handler) health(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// maxReasonLen denotes the maximum length, in bytes, of the reason given for a
+// purge.
+const maxReasonLen = 1 << 9
+
 func (h *handler) purge(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
-		URL string `json:"url"`
+		URL       string            `json:"url"`
+		Scope     string            `json:"scope"`
+		Pattern   string            `json:"pattern"`
+		Keys      []string          `json:"keys"`
+		Soft      bool              `json:"soft"`
+		NotBefore *time.Time        `json:"not_before"`
+		Reason    string            `json:"reason"`
+		Options   map[string]string `json:"options"`
 	}
 
 	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&payload); err != nil || !common.IsValidURL(payload.URL) {
+	if err := dec.Decode(&payload); err != nil || !common.IsValidURL(payload.URL) || len(payload.Reason) > maxReasonLen || !areValidOptions(payload.Options) {
 		render.UnprocessableEntity(w)
 
 		return
 	}
 
-	if !h.cache.EnqueuePurgeRequest(h.logger, payload.URL) {
+	if payload.Scope == "" {
+		payload.Scope = cache.ScopeHost
+	}
+
+	ctx := r.Context()
+	pr := &cache.PurgeRequest{
+		URL:       payload.URL,
+		Scope:     payload.Scope,
+		Pattern:   payload.Pattern,
+		Keys:      payload.Keys,
+		Soft:      payload.Soft,
+		Requester: middleware.Principal(ctx),
+		Addr:      middleware.ClientAddr(ctx),
+		UserAgent: r.UserAgent(),
+		Reason:    payload.Reason,
+		Trace:     traceParent(r),
+		Options:   payload.Options,
+	}
+
+	if !isValidScope(pr) {
+		render.UnprocessableEntity(w)
+
+		return
+	}
+
+	if payload.NotBefore != nil && payload.NotBefore.After(time.Now()) {
+		h.schedule(w, pr, *payload.NotBefore)
+
+		return
+	}
+
+	id, ok := h.cache.EnqueuePurgeRequest(h.logger, pr)
+	if !ok {
 		render.InternalServerError(w)
 
 		return
 	}
 
+	// the status of the purge may be tracked via the location
+	w.Header().Set("Location", "/purges/"+id)
+
 	render.NoContent(w)
 }
+
+// The limits of the options purge requests may carry.
+const (
+	maxOptions        = 16
+	maxOptionKeyLen   = 64
+	maxOptionValueLen = 256
+)
+
+// areValidOptions reports whether the given purge request options are within
+// limits.
+func areValidOptions(options map[string]string) bool {
+	if len(options) > maxOptions {
+		return false
+	}
+
+	for k, v := range options {
+		if k == "" || len(k) > maxOptionKeyLen || len(v) > maxOptionValueLen {
+			return false
+		}
+	}
+
+	return true
+}
+
+// maxTraceParentLen denotes the maximum length of the traceparent headers
+// purge requests carry along.
+const maxTraceParentLen = 128
+
+// traceParent returns the W3C trace context the given request carries, if any.
+func traceParent(r *http.Request) string {
+	if tp := r.Header.Get("traceparent"); len(tp) <= maxTraceParentLen {
+		return tp
+	}
+
+	return ""
+}
+
+// isValidScope reports whether the scope of the given purge request is a
+// valid one, and whether it carries exactly the fields its scope requires.
+func isValidScope(pr *cache.PurgeRequest) bool {
+	if !cache.IsValidScope(pr.Scope) {
+		return false
+	}
+
+	if (pr.Scope == cache.ScopeRegex) != (pr.Pattern != "") || strings.IndexFunc(pr.Pattern, unicode.IsSpace) >= 0 {
+		return false
+	}
+
+	if (pr.Scope == cache.ScopeXKey) != (len(pr.Keys) > 0) {
+		return false
+	}
+	for _, key := range pr.Keys {
+		if key == "" || strings.IndexFunc(key, unicode.IsSpace) >= 0 {
+			return false
+		}
+	}
+
+	// soft purges only apply to individual objects
+	return !pr.Soft || pr.Scope == cache.ScopeExact || pr.Scope == cache.ScopeXKey
+}
+
+func (h *handler) schedule(w http.ResponseWriter, pr *cache.PurgeRequest, notBefore time.Time) {
+	id, ok := h.cache.SchedulePurgeRequest(h.logger, pr, notBefore)
+	if !ok {
+		render.InternalServerError(w)
+
+		return
+	}
+
+	render.JSON(w, http.StatusAccepted, cache.ScheduledPurge{
+		ID:           id,
+		NotBefore:    notBefore.UTC(),
+		PurgeRequest: *pr,
+	})
+}
+
+func (h *handler) scheduled(w http.ResponseWriter, r *http.Request) {
+	purges, ok := h.cache.ScheduledPurges(h.logger)
+	if !ok {
+		render.InternalServerError(w)
+
+		return
+	}
+
+	render.JSON(w, http.StatusOK, purges)
+}
+
+func (h *handler) cancel(w http.ResponseWriter, r *http.Request) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	switch found, ok := h.cache.CancelScheduledPurge(h.logger, id); {
+	case !ok:
+		render.InternalServerError(w)
+	case !found:
+		render.NotFound(w)
+	default:
+		render.NoContent(w)
+	}
+}
+
+// audit renders the entries of the page the request queries for. The cursor of
+// the following page, if any, is linked to via the Link header, as the body
+// consists of nothing but the entries.
+func (h *handler) audit(w http.ResponseWriter, r *http.Request) {
+	page, ok := h.query(w, r)
+	if !ok {
+		return
+	}
+
+	if page.Next != "" {
+		w.Header().Set("Link", nextLink(r.URL, page.Next))
+	}
+
+	render.JSON(w, http.StatusOK, page.Entries)
+}
+
+// nextLink returns the Link header value which points to the page of the given
+// cursor.
+func nextLink(u *url.URL, cursor string) string {
+	query := u.Query()
+	query.Set("cursor", cursor)
+
+	next := url.URL{Path: u.Path, RawQuery: query.Encode()}
+
+	return "<" + next.RequestURI() + `>; rel="next"`
+}
+
+func (h *handler) purges(w http.ResponseWriter, r *http.Request) {
+	if page, ok := h.query(w, r); ok {
+		render.JSON(w, http.StatusOK, page)
+	}
+}
+
+func (h *handler) status(w http.ResponseWriter, r *http.Request) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	if !cache.IsStreamID(id) {
+		render.NotFound(w)
+
+		return
+	}
+
+	switch st, found, ok := h.cache.Status(h.logger, id); {
+	case !ok:
+		render.InternalServerError(w)
+	case !found:
+		render.NotFound(w)
+	default:
+		render.JSON(w, http.StatusOK, st)
+	}
+}
+
+// query runs the purge stream query the request describes. In case query
+// reports false, it has already rendered a response.
+func (h *handler) query(w http.ResponseWriter, r *http.Request) (page cache.Page, ok bool) {
+	query := r.URL.Query()
+
+	q := cache.Query{
+		Host:      query.Get("host"),
+		Requester: query.Get("requester"),
+		Scope:     query.Get("scope"),
+		Cursor:    query.Get("cursor"),
+	}
+
+	switch order := query.Get("order"); order {
+	case "", "desc":
+		break
+	case "asc":
+		q.Ascending = true
+	default:
+		render.UnprocessableEntity(w)
+
+		return
+	}
+
+	if q.Since, ok = parseTime(query.Get("since")); !ok {
+		render.UnprocessableEntity(w)
+
+		return
+	}
+	if q.Until, ok = parseTime(query.Get("until")); !ok {
+		render.UnprocessableEntity(w)
+
+		return
+	}
+	if q.Limit, ok = parseLimit(query.Get("limit")); !ok {
+		render.UnprocessableEntity(w)
+
+		return
+	}
+	if ok = q.Cursor == "" || cache.IsStreamID(q.Cursor); !ok {
+		render.UnprocessableEntity(w)
+
+		return
+	}
+
+	if page, ok = h.cache.Entries(h.logger, &q); !ok {
+		render.InternalServerError(w)
+	}
+
+	return
+}
+
+// parseTime parses the given, optional, RFC 3339 timestamp.
+func parseTime(v string) (t time.Time, ok bool) {
+	if v == "" {
+		return t, true
+	}
+
+	var err error
+	t, err = time.Parse(time.RFC3339, v)
+
+	return t, err == nil
+}
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// parseLimit parses the given, optional, result limit.
+func parseLimit(v string) (limit int, ok bool) {
+	if v == "" {
+		return defaultLimit, true
+	}
+
+	var err error
+	if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxLimit {
+		return 0, false
+	}
+
+	return limit, true
+}
@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+func TestIsValidScope(t *testing.T) {
+	cases := []struct {
+		pr  cache.PurgeRequest
+		exp bool
+	}{
+		0:  {cache.PurgeRequest{Scope: cache.ScopeHost}, true},
+		1:  {cache.PurgeRequest{Scope: "bogus"}, false},
+		2:  {cache.PurgeRequest{Scope: cache.ScopeExact, Soft: true}, true},
+		3:  {cache.PurgeRequest{Scope: cache.ScopePrefix, Soft: true}, false},
+		4:  {cache.PurgeRequest{Scope: cache.ScopeRegex, Pattern: `\.css$`}, true},
+		5:  {cache.PurgeRequest{Scope: cache.ScopeRegex}, false},
+		6:  {cache.PurgeRequest{Scope: cache.ScopeRegex, Pattern: "a\nb"}, false},
+		7:  {cache.PurgeRequest{Scope: cache.ScopeHost, Pattern: "a"}, false},
+		8:  {cache.PurgeRequest{Scope: cache.ScopeXKey, Keys: []string{"a", "b"}, Soft: true}, true},
+		9:  {cache.PurgeRequest{Scope: cache.ScopeXKey}, false},
+		10: {cache.PurgeRequest{Scope: cache.ScopeXKey, Keys: []string{"a b"}}, false},
+		11: {cache.PurgeRequest{Scope: cache.ScopeExact, Keys: []string{"a"}}, false},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			assert.Equal(t, kase.exp, isValidScope(&kase.pr))
+		})
+	}
+}
+
+func TestMetricsRequireAuth(t *testing.T) {
+	h := newHandler(zap.NewNop(), nil, &Options{APIKey: "s3cr3t"})
+
+	cases := []struct {
+		key string
+		exp int
+	}{
+		0: {exp: http.StatusUnauthorized},
+		1: {key: "wrong", exp: http.StatusUnauthorized},
+		2: {key: "s3cr3t", exp: http.StatusOK},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if kase.key != "" {
+				req.SetBasicAuth(kase.key, "")
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, kase.exp, rec.Code)
+		})
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	u, err := url.Parse("/audit?host=example.com&cursor=1-1&limit=2")
+	require.NoError(t, err)
+
+	assert.Equal(t, `</audit?cursor=2-0&host=example.com&limit=2>; rel="next"`, nextLink(u, "2-0"))
+}
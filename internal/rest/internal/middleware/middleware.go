@@ -1,7 +1,13 @@
 package middleware
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,20 +19,143 @@ import (
 )
 
 // Auth implements a BasicAuth middleware.
+//
+// Requests made over TLS connections which presented a verified client
+// certificate are let through without BasicAuth and carry the identity of the
+// certificate as their Principal. All other requests Auth lets through carry
+// the identity of the key they authenticated with as their Principal.
 func Auth(key string, h http.Handler) http.Handler {
+	keyPrincipal := keyPrincipal(key)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user, _, ok := r.BasicAuth()
+		principal := certPrincipal(r.TLS)
+
+		if principal == "" {
+			user, _, ok := r.BasicAuth()
+
+			if !ok || !safe.Compare(key, user) {
+				render.Unauthorized(w)
 
-		if !ok || !safe.Compare(key, user) {
-			render.Unauthorized(w)
+				return
+			}
 
-			return
+			principal = keyPrincipal
 		}
 
-		h.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), principalKey{}, principal)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// keyPrincipal returns the identity of the given API key, which is derived
+// from, but doesn't disclose, the key itself.
+func keyPrincipal(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return "key:" + hex.EncodeToString(sum[:6])
+}
+
+// certPrincipal returns the identity of the verified client certificate of the
+// given connection, if any.
+func certPrincipal(cs *tls.ConnectionState) string {
+	if cs == nil || len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	leaf := cs.VerifiedChains[0][0]
+
+	switch {
+	case leaf.Subject.CommonName != "":
+		return "cert:" + leaf.Subject.CommonName
+	case len(leaf.DNSNames) > 0:
+		return "cert:" + leaf.DNSNames[0]
+	case len(leaf.EmailAddresses) > 0:
+		return "cert:" + leaf.EmailAddresses[0]
+	default:
+		return "cert:" + leaf.SerialNumber.String()
+	}
+}
+
+type principalKey struct{}
+
+// Principal returns the identity of the authenticated requester of the request
+// the given Context belongs to, or an empty string for requests which haven't
+// been authenticated.
+func Principal(ctx context.Context) string {
+	p, _ := ctx.Value(principalKey{}).(string)
+
+	return p
+}
+
+// Proxy implements a middleware which resolves the address of the client that
+// originated each request, respecting the X-Forwarded-For header of requests
+// relayed by any of the given trusted proxies.
+func Proxy(trusted []*net.IPNet, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := clientAddr(r, trusted)
+
+		ctx := context.WithValue(r.Context(), clientAddrKey{}, addr)
+		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+type clientAddrKey struct{}
+
+// ClientAddr returns the address of the client that originated the request the
+// given Context belongs to, as resolved by Proxy.
+func ClientAddr(ctx context.Context) string {
+	addr, _ := ctx.Value(clientAddrKey{}).(string)
+
+	return addr
+}
+
+func clientAddr(r *http.Request, trusted []*net.IPNet) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	if !isTrusted(addr, trusted) {
+		return addr
+	}
+
+	// walk the forwarded chain from the nearest hop backwards, stopping at the
+	// first hop we don't trust.
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+
+		addr = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+
+	return addr
+}
+
+func isTrusted(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Log wraps the Handler with logging.
 func Log(logger *zap.Logger, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -60,6 +189,13 @@ func (rec *recorder) WriteHeader(status int) {
 	rec.ResponseWriter.WriteHeader(status)
 }
 
+// Flush implements http.Flusher for recorder.
+func (rec *recorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func fetchRecorder(w http.ResponseWriter) (rec *recorder) {
 	rec = recorders.Get().(*recorder)
 	rec.ResponseWriter = w
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientAddr(t *testing.T) {
+	trusted := []*net.IPNet{
+		mustParseCIDR("10.0.0.0/8"),
+		mustParseCIDR("fd00::/8"),
+	}
+
+	cases := []struct {
+		remote string
+		xff    []string
+		exp    string
+	}{
+		0: {"1.2.3.4:5", nil, "1.2.3.4"},
+		1: {"1.2.3.4:5", []string{"6.6.6.6"}, "1.2.3.4"},
+		2: {"10.0.0.1:5", nil, "10.0.0.1"},
+		3: {"10.0.0.1:5", []string{"6.6.6.6"}, "6.6.6.6"},
+		4: {"10.0.0.1:5", []string{"7.7.7.7, 6.6.6.6, 10.0.0.2"}, "6.6.6.6"},
+		5: {"10.0.0.1:5", []string{"7.7.7.7", "6.6.6.6"}, "6.6.6.6"},
+		6: {"10.0.0.1:5", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		7: {"[fd00::1]:5", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = kase.remote
+			for _, v := range kase.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			assert.Equal(t, kase.exp, clientAddr(r, trusted))
+		})
+	}
+}
+
+func mustParseCIDR(v string) *net.IPNet {
+	_, n, err := net.ParseCIDR(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}
@@ -1,13 +1,30 @@
 // Package render implements rendering helpers.
 package render
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+)
 
 // NoContent writes a HTTP 204 No Content response to the given ResponseWriter.
 func NoContent(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// JSON writes a HTTP response with the given status code and the JSON
+// representation of v as its body to the given ResponseWriter.
+func JSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// NotFound writes a HTTP 404 Not Found response to the given ResponseWriter.
+func NotFound(w http.ResponseWriter) {
+	code(w, http.StatusNotFound)
+}
+
 // UnprocessableEntity writes a HTTP 422 Unprocessable Entity response to the
 // given ResponseWriter.
 func UnprocessableEntity(w http.ResponseWriter) {
@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	unixPrefix    = "unix:"
+	systemdPrefix = "systemd:"
+)
+
+// listenUnix binds a Unix domain socket listener on the given path, replacing
+// any stale socket that may exist there.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("rest: empty unix socket path")
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// the socket is created with no more permissions than it's meant to have,
+	// rather than narrowed down once it's reachable. The mask is process-wide,
+	// which is fine as listeners are bound during startup.
+	mask := umask(0o777 &^ int(mode.Perm()))
+	l, err := net.Listen("unix", path)
+	umask(mask)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		_ = l.Close()
+
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// listenFDsStart denotes the first file descriptor systemd passes in.
+const listenFDsStart = 3
+
+// listenSystemd returns a listener for the socket systemd passed in via socket
+// activation. In case name is empty, the first socket is returned. Otherwise
+// the socket with the given name (as configured via FileDescriptorName=) is.
+func listenSystemd(name string) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("rest: no sockets passed in by systemd")
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("rest: no sockets passed in by systemd")
+	}
+
+	index := 0
+	if name != "" {
+		if index = indexOf(strings.Split(os.Getenv("LISTEN_FDNAMES"), ":"), name); index < 0 || index >= n {
+			return nil, fmt.Errorf("rest: no socket named %q passed in by systemd", name)
+		}
+	}
+
+	f := os.NewFile(uintptr(listenFDsStart+index), "systemd:"+name)
+	defer f.Close() // FileListener dups the descriptor
+
+	return net.FileListener(f)
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,49 @@
+package rest
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "purgery.sock")
+
+	// a stale socket, as left behind by a process which crashed
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	l, err := listenUnix(path, 0o600)
+	require.NoError(t, err)
+	defer l.Close()
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+}
+
+func TestListenUnixRefusesRegularFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+	_, err := listenUnix(path, 0o600)
+	assert.Error(t, err)
+}
+
+func TestListenSystemdWithoutSockets(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+
+	_, err := listenSystemd("")
+	assert.Error(t, err)
+}
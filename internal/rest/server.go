@@ -3,8 +3,11 @@ package rest
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,36 +18,71 @@ import (
 	"github.com/soupedup/purgery/internal/common"
 )
 
-// Bind binds a TCP listener on the given address and returns a reference
-// to it.
-func Bind(logger *zap.Logger, on string) (l net.Listener, err error) {
+// Bind binds a listener on the given address and returns a reference to it.
+//
+// Addresses of the form unix:<path> denote Unix domain sockets, which will be
+// created with the given permissions. Addresses of the form systemd:[<name>]
+// denote sockets passed in by systemd socket activation; the first one, or the
+// one of the given name. Any other address denotes a TCP one.
+func Bind(logger *zap.Logger, on string, socketMode os.FileMode) (l net.Listener, err error) {
 	logger.Info("binding ...", zap.String("on", on))
 
-	switch l, err = net.Listen("tcp", on); err {
+	switch {
+	case strings.HasPrefix(on, unixPrefix):
+		l, err = listenUnix(on[len(unixPrefix):], socketMode)
+	case strings.HasPrefix(on, systemdPrefix):
+		l, err = listenSystemd(on[len(systemdPrefix):])
+	default:
+		l, err = net.Listen("tcp", on)
+	}
+
+	switch err {
 	default:
 		err = exit.Wrap(common.ECBind, err)
 
 		logger.Error("failed binding.", zap.Error(err))
 	case nil:
-		logger.Debug("bound.")
+		logger.Debug("bound.", zap.Stringer("addr", l.Addr()))
 	}
 
 	return
 }
 
+// Options wraps the configuration of the embedded REST server.
+type Options struct {
+	// APIKey holds the key clients authenticate with.
+	APIKey string
+
+	// TLS holds the TLS configuration the server uses, if any.
+	TLS *tls.Config
+
+	// TrustedProxies holds the networks of the proxies whose X-Forwarded-For
+	// headers are trusted when resolving the addresses of clients.
+	TrustedProxies []*net.IPNet
+}
+
 // Serve takes ownership of l and starts serving HTTP requests from clients it
 // accepts on it via h until l encounters a terminal error or ctx has been
 // terminated.
 //
 // When Serve returns l will be closed. Contrary to similar functions of the
 // http package, Serve reports nil instead of http.ErrServerClosed.
-func Serve(ctx context.Context, logger *zap.Logger, l net.Listener, c *cache.Cache, apiKey string) (err error) {
+func Serve(ctx context.Context, logger *zap.Logger, l net.Listener, c *cache.Cache, opts *Options) (err error) {
 	srv := &http.Server{
 		ReadHeaderTimeout: time.Second << 3,
 		IdleTimeout:       time.Minute,
 		MaxHeaderBytes:    1 << 12,
 		ErrorLog:          zap.NewStdLog(logger),
-		Handler:           newHandler(logger, c, apiKey),
+		Handler:           newHandler(logger, c, opts),
+		BaseContext: func(net.Listener) context.Context {
+			// long-lived requests, like event streams, should stop once ctx
+			// is done
+			return ctx
+		},
+	}
+
+	if opts.TLS != nil {
+		l = tls.NewListener(l, opts.TLS)
 	}
 
 	var wg sync.WaitGroup
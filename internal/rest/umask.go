@@ -0,0 +1,11 @@
+//go:build !windows && !plan9
+
+package rest
+
+import "syscall"
+
+// umask sets the file mode creation mask of the process and returns the
+// previous one.
+func umask(mask int) int {
+	return syscall.Umask(mask)
+}
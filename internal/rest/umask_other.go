@@ -0,0 +1,8 @@
+//go:build windows || plan9
+
+package rest
+
+// umask is a no-op on platforms which have no file mode creation mask.
+func umask(int) int {
+	return 0
+}
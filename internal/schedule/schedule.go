@@ -0,0 +1,59 @@
+// Package schedule implements the promotion of scheduled purge requests.
+package schedule
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+const (
+	// lease names the lease instances compete for in order to promote.
+	lease = "scheduler"
+
+	// interval denotes how often due purge requests are promoted.
+	interval = time.Second
+
+	// leaseTTL denotes for how long a lease is held without being extended.
+	leaseTTL = interval * 5
+
+	// batch denotes the maximum number of purge requests a single tick
+	// promotes.
+	batch = 100
+)
+
+// Run periodically promotes scheduled purge requests which have come due into
+// the purge stream, until the given Context is cancelled.
+//
+// Only the instance holding the scheduler lease promotes at any given time.
+func Run(ctx context.Context, logger *zap.Logger, cache *cache.Cache) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Warn("context canceled; bailing ...", zap.Error(ctx.Err()))
+
+			return
+		case <-ticker.C:
+			tick(logger, cache)
+		}
+	}
+}
+
+func tick(logger *zap.Logger, cache *cache.Cache) {
+	if !cache.AcquireLease(logger, lease, leaseTTL) {
+		return // another instance promotes
+	}
+
+	// keep going while full batches are being promoted
+	for {
+		if n, ok := cache.PromoteScheduledPurges(logger, batch); !ok || n < batch {
+			return
+		}
+	}
+}
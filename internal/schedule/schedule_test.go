@@ -0,0 +1,99 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+)
+
+// leaseConn implements a redis.Conn which emulates the lease and promotion
+// scripts. It serves a number of due purge requests and records which process
+// promoted them.
+type leaseConn struct {
+	owner    string         // of the lease
+	due      int            // number of due requests not yet promoted
+	promoted map[string]int // number of requests promoted, by process
+	calls    int            // number of promotions
+}
+
+func (c *leaseConn) newCache(purgeryID string) *cache.Cache {
+	return cache.New(purgeryID, &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return c, nil
+		},
+	})
+}
+
+func (c *leaseConn) Close() error { return nil }
+func (c *leaseConn) Err() error   { return nil }
+
+func (c *leaseConn) Send(string, ...interface{}) error { return errors.New("not implemented") }
+func (c *leaseConn) Flush() error                      { return errors.New("not implemented") }
+func (c *leaseConn) Receive() (interface{}, error)     { return nil, errors.New("not implemented") }
+
+func (c *leaseConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch {
+	case cmd == "":
+		return nil, nil // connection pools flush via empty commands
+	case cmd != "EVALSHA":
+		return nil, errors.New("unexpected command: " + cmd)
+	}
+
+	// scripts are told apart by the keys they're passed
+	switch keys := args[1].(int); {
+	case keys == 1 && args[2] == "purgery:leases:"+lease:
+		// lease, owner, ttl
+		if owner := args[3].(string); c.owner == "" || c.owner == owner {
+			c.owner = owner
+
+			return int64(1), nil
+		}
+
+		return int64(0), nil
+	case keys == 3 && args[2] == "purgery:scheduled":
+		// scheduled, requests, stream, max
+		n := args[5].(int)
+		if n > c.due {
+			n = c.due
+		}
+		c.due -= n
+		c.promoted[c.owner] += n
+		c.calls++
+
+		return int64(n), nil
+	}
+
+	return nil, errors.New("unexpected script")
+}
+
+func TestTick(t *testing.T) {
+	conn := &leaseConn{
+		due:      2*batch + 1,
+		promoted: make(map[string]int),
+	}
+	a, b := conn.newCache("a"), conn.newCache("b")
+	logger := zap.NewNop()
+
+	// only the process which holds the lease promotes, and it keeps going
+	// while full batches are promoted
+	tick(logger, a)
+	tick(logger, b)
+
+	assert.Equal(t, map[string]int{"a": 2*batch + 1}, conn.promoted)
+	assert.Equal(t, 3, conn.calls)
+
+	// the lease holder keeps promoting on later ticks
+	conn.due = 1
+
+	tick(logger, b)
+	tick(logger, a)
+
+	assert.Equal(t, map[string]int{"a": 2*batch + 2}, conn.promoted)
+	assert.Equal(t, 4, conn.calls)
+	assert.Zero(t, conn.due)
+}
@@ -0,0 +1,174 @@
+// Package tlsconfig implements the construction of TLS configurations.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Server returns a server TLS configuration which presents the certificate of
+// the given files.
+//
+// The certificate is reloaded whenever either of its files changes on disk.
+//
+// In case caFile isn't empty, client certificates are verified against the
+// certificate authorities it contains. Clients are required to present one
+// only when requireClientCert is set.
+func Server(logger *zap.Logger, certFile, keyFile, caFile string, requireClientCert bool) (*tls.Config, error) {
+	kp := &keyPair{
+		logger:   logger,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := kp.load(); err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: kp.get,
+	}
+
+	if caFile != "" {
+		pool, err := loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = pool
+		if requireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	} else if requireClientCert {
+		return nil, errors.New("tlsconfig: client certificates required without a CA bundle")
+	}
+
+	return cfg, nil
+}
+
+// Client returns a client TLS configuration which verifies servers against the
+// given server name and the certificate authorities caFile contains.
+//
+// In case caFile is empty the system's certificate authorities are used
+// instead.
+func Client(serverName, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if caFile != "" {
+		pool, err := loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// loadPool returns a certificate pool which contains the PEM-encoded
+// certificates of the given file.
+func loadPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tlsconfig: no certificates found in %q", file)
+	}
+
+	return pool, nil
+}
+
+// checkEvery denotes how often keyPair checks its files for changes.
+const checkEvery = 5 * time.Second
+
+// keyPair implements a certificate which is reloaded when its files change.
+type keyPair struct {
+	logger   *zap.Logger
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	checkedAt time.Time
+}
+
+func (kp *keyPair) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	if time.Since(kp.checkedAt) >= checkEvery {
+		kp.checkedAt = time.Now()
+
+		if kp.changed() {
+			kp.logger.Info("reloading tls certificate ...")
+
+			if err := kp.load(); err != nil {
+				// keep serving the previous certificate
+				kp.logger.Error("failed reloading tls certificate.",
+					zap.Error(err))
+			} else {
+				kp.logger.Debug("tls certificate reloaded.")
+			}
+		}
+	}
+
+	return kp.cert, nil
+}
+
+func (kp *keyPair) changed() bool {
+	certMod, keyMod, err := kp.modTimes()
+
+	return err == nil && (!certMod.Equal(kp.certMod) || !keyMod.Equal(kp.keyMod))
+}
+
+func (kp *keyPair) modTimes() (certMod, keyMod time.Time, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(kp.certFile); err != nil {
+		return
+	}
+	certMod = fi.ModTime()
+
+	if fi, err = os.Stat(kp.keyFile); err != nil {
+		return
+	}
+	keyMod = fi.ModTime()
+
+	return
+}
+
+// load loads the certificate; callers must hold the lock, or have exclusive
+// access to the keyPair.
+func (kp *keyPair) load() error {
+	certMod, keyMod, err := kp.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		return err
+	}
+
+	kp.cert = &cert
+	kp.certMod, kp.keyMod = certMod, keyMod
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestKeyPairReloads(t *testing.T) {
+	dir := t.TempDir()
+
+	kp := &keyPair{
+		logger:   zap.NewNop(),
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+	}
+
+	writeKeyPair(t, kp.certFile, kp.keyFile, "first")
+	require.NoError(t, kp.load())
+	assert.Equal(t, "first", commonName(t, kp))
+
+	writeKeyPair(t, kp.certFile, kp.keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(kp.certFile, future, future))
+
+	// changes are only picked up once checkEvery has passed
+	kp.checkedAt = time.Now()
+	assert.Equal(t, "first", commonName(t, kp))
+
+	kp.checkedAt = time.Time{}
+	assert.Equal(t, "second", commonName(t, kp))
+
+	// broken files don't replace the current certificate
+	require.NoError(t, os.WriteFile(kp.keyFile, []byte("garbage"), 0o600))
+	require.NoError(t, os.Chtimes(kp.keyFile, future, future))
+
+	kp.checkedAt = time.Time{}
+	assert.Equal(t, "second", commonName(t, kp))
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	writeKeyPair(t, certFile, keyFile, "server")
+
+	_, err := Server(zap.NewNop(), certFile, keyFile, "", true)
+	assert.Error(t, err, "client certs required without a CA")
+
+	_, err = Server(zap.NewNop(), certFile, keyFile, keyFile, false)
+	assert.Error(t, err, "CA bundle without certificates")
+
+	cfg, err := Server(zap.NewNop(), certFile, keyFile, certFile, true)
+	require.NoError(t, err)
+	assert.NotNil(t, cfg.ClientCAs)
+}
+
+func commonName(t *testing.T, kp *keyPair) string {
+	t.Helper()
+
+	cert, err := kp.get(nil)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	return leaf.Subject.CommonName
+}
+
+func writeKeyPair(t *testing.T, certFile, keyFile, cn string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	t.Helper()
+
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	require.NoError(t, os.WriteFile(file, data, 0o600))
+}
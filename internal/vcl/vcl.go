@@ -0,0 +1,157 @@
+// Package vcl implements the rendering of the VCL which applies the purge
+// requests purgery sends.
+package vcl
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/purge"
+)
+
+//go:embed templates
+var templates embed.FS
+
+var tmpl = template.Must(template.ParseFS(templates, "templates/*.tmpl"))
+
+// Config wraps the configuration of the rendered VCL.
+type Config struct {
+	// Scopes holds the purge scopes the VCL applies. Purges of other scopes
+	// are rejected.
+	Scopes []string
+
+	// Soft reports whether soft purges of exact- and xkey-scoped purges are
+	// applied as such. Otherwise they're applied as hard ones.
+	Soft bool
+
+	// Token holds the token purge requests must carry, if any.
+	Token string
+
+	// ACL holds the addresses and networks purge requests must originate
+	// from, if any.
+	ACL []string
+
+	// Backend holds the host:port address of the backend the VCL fetches from.
+	Backend string
+
+	// LegacyBans reports whether bans also apply to objects cached by the VCL
+	// purgery shipped before it rendered its own, which recorded nothing but
+	// the host of objects, as host. Such objects are banned by host, whatever
+	// the scope of the purge.
+	LegacyBans bool
+}
+
+// Render renders, into w, the VCL the given Config describes.
+func Render(w io.Writer, cfg *Config) error {
+	d, err := newData(cfg)
+	if err != nil {
+		return err
+	}
+
+	return tmpl.ExecuteTemplate(w, "default.vcl.tmpl", d)
+}
+
+// headers wraps the names of the headers purge requests carry.
+type headers struct {
+	Scope   string
+	Pattern string
+	Keys    string
+	Soft    string
+	Token   string
+}
+
+// data wraps the values the template renders.
+type data struct {
+	*Config
+
+	BackendHost string
+	BackendPort string
+	Headers     headers
+
+	enabled map[string]bool
+}
+
+// Enabled reports whether the given scope is enabled.
+func (d *data) Enabled(scope string) bool {
+	return d.enabled[scope]
+}
+
+func newData(cfg *Config) (d *data, err error) {
+	d = &data{
+		Headers: headers{
+			Scope:   purge.ScopeHeader,
+			Pattern: purge.PatternHeader,
+			Keys:    purge.KeysHeader,
+			Soft:    purge.SoftHeader,
+			Token:   purge.TokenHeader,
+		},
+		enabled: make(map[string]bool, len(cfg.Scopes)),
+	}
+
+	if len(cfg.Scopes) == 0 {
+		return nil, errNoScopes
+	}
+	for _, scope := range cfg.Scopes {
+		if !cache.IsValidScope(scope) {
+			return nil, fmt.Errorf("vcl: invalid scope (%q)", scope)
+		}
+		d.enabled[scope] = true
+	}
+
+	if cfg.Soft && !d.enabled[cache.ScopeExact] && !d.enabled[cache.ScopeXKey] {
+		return nil, errors.New("vcl: soft purges require the exact or xkey scope")
+	}
+
+	if !isValidString(cfg.Token) {
+		return nil, fmt.Errorf("vcl: invalid token (%q)", cfg.Token)
+	}
+
+	acl := make([]string, len(cfg.ACL))
+	for i, entry := range cfg.ACL {
+		if acl[i], err = aclEntry(entry); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.BackendHost, d.BackendPort, err = net.SplitHostPort(cfg.Backend); err != nil {
+		return nil, fmt.Errorf("vcl: invalid backend (%q): %w", cfg.Backend, err)
+	}
+	if !isValidString(d.BackendHost) || d.BackendHost == "" {
+		return nil, fmt.Errorf("vcl: invalid backend (%q)", cfg.Backend)
+	}
+
+	cp := *cfg
+	cp.ACL = acl
+	d.Config = &cp
+
+	return d, nil
+}
+
+var errNoScopes = errors.New("vcl: no scopes enabled")
+
+// aclEntry returns the VCL representation of the given address or network.
+func aclEntry(entry string) (string, error) {
+	if ip := net.ParseIP(entry); ip != nil {
+		return fmt.Sprintf("%q", ip.String()), nil
+	}
+
+	_, network, err := net.ParseCIDR(entry)
+	if err != nil {
+		return "", fmt.Errorf("vcl: invalid acl entry (%q)", entry)
+	}
+	ones, _ := network.Mask.Size()
+
+	return fmt.Sprintf("%q/%d", network.IP.String(), ones), nil
+}
+
+// isValidString reports whether the given value may be placed, as is, inside
+// a VCL string literal.
+func isValidString(s string) bool {
+	return !strings.ContainsAny(s, "\"\r\n")
+}
@@ -0,0 +1,109 @@
+package vcl
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	cases := []struct {
+		cfg      Config
+		contains []string
+		omits    []string
+	}{
+		0: {
+			cfg: Config{Scopes: []string{"host"}, Backend: "nginx:80"},
+			contains: []string{
+				`.host = "nginx";`,
+				`.port = "80";`,
+				`call purgery_ban;`,
+			},
+			omits: []string{
+				"import purge;",
+				"import xkey;",
+				"acl purgery",
+				"X-Purgery-Token",
+				"purgery_ban_pattern",
+				`"obj.http.host == " + req.http.host`,
+			},
+		},
+		1: {
+			cfg: Config{
+				Scopes:  []string{"exact", "xkey"},
+				Soft:    true,
+				Token:   "s3cr3t",
+				ACL:     []string{"127.0.0.1", "10.1.2.3/8"},
+				Backend: "[::1]:8080",
+			},
+			contains: []string{
+				"import purge;",
+				"import xkey;",
+				`.host = "::1";`,
+				`"127.0.0.1";`,
+				`"10.0.0.0"/8;`,
+				`req.http.X-Purgery-Token != "s3cr3t"`,
+				`req.http.X-Purgery-Soft && req.http.X-Purgery-Scope == "exact"`,
+				"xkey.softpurge(req.http.X-Purgery-Keys)",
+				"purgery_ban_exact",
+			},
+			omits: []string{
+				`req.http.X-Purgery-Scope == "host"`,
+			},
+		},
+		2: {
+			cfg: Config{Scopes: []string{"regex"}, Backend: "nginx:80"},
+			contains: []string{
+				`if (req.http.X-Purgery-Scope == "regex") {`,
+				"obj.http.x-purgery-url ~ \" + req.http.X-Purgery-Pattern",
+			},
+		},
+		3: {
+			cfg: Config{Scopes: []string{"host"}, Backend: "nginx:80", LegacyBans: true},
+			contains: []string{
+				`std.ban("obj.http.host == " + req.http.host)`,
+				`std.ban("obj.http.x-purgery-host == " + req.http.host`,
+			},
+		},
+	}
+
+	for caseIndex := range cases {
+		kase := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			var b strings.Builder
+			require.NoError(t, Render(&b, &kase.cfg))
+
+			got := b.String()
+			assert.True(t, strings.HasPrefix(got, "# Generated by purgery vcl"))
+			for _, s := range kase.contains {
+				assert.Contains(t, got, s)
+			}
+			for _, s := range kase.omits {
+				assert.NotContains(t, got, s)
+			}
+		})
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	cases := []Config{
+		0: {Backend: "nginx:80"},
+		1: {Scopes: []string{"bogus"}, Backend: "nginx:80"},
+		2: {Scopes: []string{"host"}, Soft: true, Backend: "nginx:80"},
+		3: {Scopes: []string{"host"}, Token: `a"b`, Backend: "nginx:80"},
+		4: {Scopes: []string{"host"}, ACL: []string{"nope"}, Backend: "nginx:80"},
+		5: {Scopes: []string{"host"}, Backend: "nginx"},
+	}
+
+	for caseIndex := range cases {
+		cfg := cases[caseIndex]
+
+		t.Run(strconv.Itoa(caseIndex), func(t *testing.T) {
+			assert.Error(t, Render(new(strings.Builder), &cfg))
+		})
+	}
+}
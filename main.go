@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"net"
+	"os"
 	"os/signal"
 	"sync"
 	"syscall"
@@ -12,9 +13,11 @@ import (
 
 	"github.com/soupedup/purgery/internal/cache"
 	"github.com/soupedup/purgery/internal/env"
+	"github.com/soupedup/purgery/internal/leader"
 	"github.com/soupedup/purgery/internal/log"
 	"github.com/soupedup/purgery/internal/purge"
 	"github.com/soupedup/purgery/internal/rest"
+	"github.com/soupedup/purgery/internal/schedule"
 )
 
 const (
@@ -23,7 +26,23 @@ const (
 	ecDialCache
 )
 
+// commands holds the set of subcommands, keyed by name.
+var commands = map[string]func(args []string) error{
+	"bench":   runBench,
+	"inspect": runInspect,
+	"purge":   runPurge,
+	"replay":  runReplay,
+	"status":  runStatus,
+	"vcl":     runVCL,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			exit.With(cmd(os.Args[2:]))
+		}
+	}
+
 	exit.With(run())
 }
 
@@ -39,23 +58,70 @@ func run() (err error) {
 	defer cancel()
 
 	cache := cache.New(cfg.PurgeryID, cfg.Redis)
+	cache.SetTarget(cfg.TargetID)
+	cache.SetStartPosition(cfg.StartPosition)
 	defer closeCache(logger, cache)
 
 	var l net.Listener
-	if l, err = rest.Bind(logger, cfg.Addr); err != nil {
+	if l, err = rest.Bind(logger, cfg.Addr, cfg.SocketMode); err != nil {
 		return
 	}
 	// we don't need to close the listener as rest.Serve will.
 
 	var wg sync.WaitGroup
 
+	fn := newPurgeFunc(cfg)
+	if cfg.DryRun {
+		logger.Warn("running dry; purges will only be logged.")
+
+		fn = fn.DryRun()
+	} else {
+		if cfg.VerifyHeader != "" {
+			verifier := purge.NewVerifier(cfg.VarnishAddr, cfg.VerifyHeader, cfg.VerifyExpect,
+				cfg.VerifyChanged, cfg.VerifyRetries, transportOptions(cfg)...)
+			fn = fn.Verify(verifier)
+		}
+
+		if cfg.Warm {
+			warmer := purge.NewWarmer(cfg.VarnishAddr, cfg.WarmPaths, cfg.WarmConcurrency, transportOptions(cfg)...)
+			fn = fn.Warm(warmer)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				warmer.Run(ctx)
+			}()
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+
+		run := func(ctx context.Context) {
+			fn.Run(ctx, logger, cache,
+				purge.WithConcurrency(cfg.PurgeConcurrency),
+				purge.WithBacklogCollapse(cfg.BacklogThreshold))
+		}
+
+		if !cfg.SharedTarget {
+			run(ctx)
+
+			return
+		}
+
+		// only the leader of the processes which share the target purges
+		leader.Run(ctx, logger, cache, run)
+	}()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		defer cancel()
 
-		purge.New(cfg.VarnishAddr).
-			Run(ctx, logger, cache)
+		schedule.Run(ctx, logger, cache)
 	}()
 
 	wg.Add(1)
@@ -63,7 +129,11 @@ func run() (err error) {
 		defer wg.Done()
 		defer cancel()
 
-		err = rest.Serve(ctx, logger, l, cache, cfg.APIKey)
+		err = rest.Serve(ctx, logger, l, cache, &rest.Options{
+			APIKey:         cfg.APIKey,
+			TLS:            cfg.TLS,
+			TrustedProxies: cfg.TrustedProxies,
+		})
 	}()
 
 	wg.Wait()
@@ -71,6 +141,44 @@ func run() (err error) {
 	return
 }
 
+func newPurgeFunc(cfg *env.Config) (fn purge.Func) {
+	opts := append(transportOptions(cfg), authOptions(cfg)...)
+
+	if cfg.VarnishBackend == env.BackendAdmin {
+		fn = purge.NewAdmin(cfg.VarnishAdminAddr, cfg.VarnishSecret).Func()
+	} else {
+		fn = purge.New(cfg.VarnishAddr, opts...)
+	}
+
+	if cfg.MirrorAddr != "" {
+		fn = fn.Mirror(purge.New(cfg.MirrorAddr, opts...))
+	}
+
+	return
+}
+
+// transportOptions returns the options which govern how Varnish is reached.
+func transportOptions(cfg *env.Config) (opts []purge.Option) {
+	if cfg.VarnishTLS != nil {
+		opts = append(opts, purge.WithTLS(cfg.VarnishTLS))
+	}
+
+	return
+}
+
+// authOptions returns the options which govern how purges authenticate.
+func authOptions(cfg *env.Config) (opts []purge.Option) {
+	for name := range cfg.VarnishHeader {
+		opts = append(opts, purge.WithHeader(name, cfg.VarnishHeader.Get(name)))
+	}
+
+	if cfg.VarnishToken != "" {
+		opts = append(opts, purge.WithToken(cfg.VarnishToken))
+	}
+
+	return
+}
+
 func closeCache(logger *zap.Logger, cache *cache.Cache) {
 	logger.Info("closing cache ...")
 
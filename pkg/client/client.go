@@ -67,6 +67,7 @@ func (err errInvalidStatusCode) Error() string {
 var (
 	errInternalServerError = errors.New("purgery: internal server error")
 	errUnauthorized        = errors.New("purgery: unauthorized")
+	errNotFound            = errors.New("purgery: not found")
 )
 
 type errInvalidURL string
@@ -76,17 +77,55 @@ func (err errInvalidURL) Error() string {
 }
 
 // Purge requests that the given URL be purged from the remote cache.
-func (c *Client) Purge(ctx context.Context, url string) (err error) {
-	payload := struct {
-		URL string `json:"url"`
-	}{
-		URL: url,
-	}
+func (c *Client) Purge(ctx context.Context, url string) error {
+	_, err := c.Enqueue(ctx, url)
+
+	return err
+}
+
+// Enqueue behaves like Purge, but also returns the ID the purge request was
+// enqueued as, which its status may be queried by.
+//
+// The returned ID is empty when the API doesn't report one.
+func (c *Client) Enqueue(ctx context.Context, url string) (id string, err error) {
+	return c.Submit(ctx, &PurgeRequest{URL: url})
+}
+
+// PurgeRequest wraps the details of a purge request.
+type PurgeRequest struct {
+	// URL holds the URL to purge.
+	URL string `json:"url"`
+
+	// Scope holds the scope of the purge; one of host (the default), exact,
+	// prefix, regex or xkey.
+	Scope string `json:"scope,omitempty"`
+
+	// Pattern holds the regular expression regex-scoped purges match URLs
+	// against.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Keys holds the keys xkey-scoped purges apply to.
+	Keys []string `json:"keys,omitempty"`
+
+	// Soft reports whether exact- and xkey-scoped purges should only expire
+	// the objects they apply to.
+	Soft bool `json:"soft,omitempty"`
+
+	// Reason holds the reason, if any, for the purge.
+	Reason string `json:"reason,omitempty"`
+
+	// Options holds any further options of the purge.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Submit behaves like Enqueue for the given purge request.
+func (c *Client) Submit(ctx context.Context, pr *PurgeRequest) (id string, err error) {
+	url := pr.URL
 
 	enc := checkoutEncoder()
 	defer enc.release()
 
-	if err = enc.Encode(payload); err != nil {
+	if err = enc.Encode(pr); err != nil {
 		return
 	}
 
@@ -105,7 +144,9 @@ func (c *Client) Purge(ctx context.Context, url string) (err error) {
 	default:
 		err = errInvalidStatusCode(res.StatusCode)
 	case http.StatusNoContent:
-		break
+		if loc := res.Header.Get("Location"); strings.HasPrefix(loc, "/purges/") {
+			id = path.Base(loc)
+		}
 	case http.StatusUnprocessableEntity:
 		err = errInvalidURL(url)
 	case http.StatusUnauthorized:
@@ -117,6 +158,73 @@ func (c *Client) Purge(ctx context.Context, url string) (err error) {
 	return
 }
 
+// Status wraps the status of a purge request.
+type Status struct {
+	// ID holds the ID the purge request was enqueued as.
+	ID string `json:"id"`
+
+	// Time holds the time the purge request was enqueued at.
+	Time time.Time `json:"time"`
+
+	// URL holds the URL of the purge request.
+	URL string `json:"url"`
+
+	// Scope holds the scope of the purge request.
+	Scope string `json:"scope,omitempty"`
+
+	// Requester holds the identity of whoever requested the purge.
+	Requester string `json:"requester,omitempty"`
+
+	// Instances holds the status of the purge request on each instance.
+	Instances []InstanceStatus `json:"instances"`
+}
+
+// InstanceStatus wraps the status of a purge request on a purgery instance.
+type InstanceStatus struct {
+	// Instance holds the ID of the instance.
+	Instance string `json:"instance"`
+
+	// State holds the state of the purge request on the instance; one of
+	// pending, applied, failed, unverified, dropped or passed (the instance
+	// is past the purge request, but its outcome is no longer on record).
+	State string `json:"state"`
+
+	// Error holds the reason the purge request failed, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Status returns the status of the purge request of the given ID.
+func (c *Client) Status(ctx context.Context, id string) (st *Status, err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, joinURL(c.rootURL, "purges", id), nil); err != nil {
+		return
+	}
+
+	var res *http.Response
+	if res, err = c.http.Do(req); err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	default:
+		err = errInvalidStatusCode(res.StatusCode)
+	case http.StatusOK:
+		st = new(Status)
+		if err = json.NewDecoder(res.Body).Decode(st); err != nil {
+			st = nil
+		}
+	case http.StatusNotFound:
+		err = errNotFound
+	case http.StatusUnauthorized:
+		err = errUnauthorized
+	case http.StatusInternalServerError:
+		err = errInternalServerError
+	}
+
+	return
+}
+
 func buildClient(apiKey string) *http.Client {
 	return &http.Client{
 		Timeout: 30 * time.Second,
@@ -115,6 +115,55 @@ func TestPurge(t *testing.T) {
 	}
 }
 
+func TestEnqueue(t *testing.T) {
+	srv := newServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/purges/1-2")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	defer srv.Close()
+
+	id, err := New(srv.URL, "").Enqueue(context.Background(), "http://example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, "1-2", id)
+}
+
+func TestStatus(t *testing.T) {
+	srv := newServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			panic(fmt.Errorf("invalid method: %q", r.Method))
+		}
+
+		switch r.URL.Path {
+		case "/purges/1-2":
+			_, _ = w.Write([]byte(`{
+				"id": "1-2",
+				"time": "1970-01-01T00:00:00.001Z",
+				"url": "http://example.com/",
+				"instances": [{"instance": "a", "state": "failed", "error": "boom"}]
+			}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer srv.Close()
+
+	client := New(srv.URL, "")
+
+	st, err := client.Status(context.Background(), "1-2")
+	require.NoError(t, err)
+	assert.Equal(t, &Status{
+		ID:   "1-2",
+		Time: time.UnixMilli(1).UTC(),
+		URL:  "http://example.com/",
+		Instances: []InstanceStatus{
+			{Instance: "a", State: "failed", Error: "boom"},
+		},
+	}, st)
+
+	_, err = client.Status(context.Background(), "3-4")
+	assert.Equal(t, errNotFound, err)
+}
+
 func newServer(fn http.HandlerFunc) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(fn))
 }
@@ -0,0 +1,172 @@
+package purgerytest
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Backend implements a fake cache which records the BAN and PURGE requests it
+// receives, as purgery sends them.
+//
+// Instances of Backend are safe for concurrent use.
+type Backend struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	requests []BackendRequest
+	code     int
+}
+
+// BackendRequest wraps a purge request a Backend has received.
+type BackendRequest struct {
+	// Method holds the method of the request; either BAN or PURGE.
+	Method string
+
+	// Host holds the host the request is for.
+	Host string
+
+	// URI holds the request URI of the request.
+	URI string
+
+	// Header holds the headers of the request.
+	Header http.Header
+}
+
+// URL returns the URL the BackendRequest purges.
+func (br *BackendRequest) URL() string {
+	return "http://" + br.Host + br.URI
+}
+
+// NewBackend starts and returns a Backend.
+//
+// Callers should Close the Backend when done with it.
+func NewBackend() *Backend {
+	b := &Backend{code: http.StatusOK}
+	b.srv = httptest.NewServer(b)
+
+	return b
+}
+
+// ListenBackend starts and returns a Backend which listens on the given TCP
+// address, i.e. one that purgery instances running elsewhere already target.
+//
+// Callers should Close the Backend when done with it.
+func ListenBackend(addr string) (*Backend, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Backend{code: http.StatusOK}
+	b.srv = httptest.NewUnstartedServer(b)
+	b.srv.Listener.Close()
+	b.srv.Listener = l
+	b.srv.Start()
+
+	return b, nil
+}
+
+// Addr returns the address of the Backend, as expected by purgery's
+// VARNISH_ADDR.
+func (b *Backend) Addr() string {
+	return b.srv.Listener.Addr().String()
+}
+
+// Close shuts the Backend down.
+func (b *Backend) Close() {
+	b.srv.Close()
+}
+
+// Respond makes the Backend respond to purge requests with the given status
+// code, which defaults to 200. Requests are recorded regardless.
+func (b *Backend) Respond(code int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.code = code
+}
+
+// Requests returns the purge requests the Backend has received, in order.
+func (b *Backend) Requests() []BackendRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]BackendRequest(nil), b.requests...)
+}
+
+// Reset forgets the purge requests the Backend has received.
+func (b *Backend) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.requests = nil
+}
+
+// ServeHTTP implements http.Handler for Backend.
+func (b *Backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "BAN" && r.Method != "PURGE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	b.mu.Lock()
+	b.requests = append(b.requests, BackendRequest{
+		Method: r.Method,
+		Host:   r.Host,
+		URI:    r.RequestURI,
+		Header: r.Header.Clone(),
+	})
+	code := b.code
+	b.mu.Unlock()
+
+	w.WriteHeader(code)
+}
+
+// AssertPurged reports, and fails t when it isn't the case, whether the
+// Backend has received a purge request for the given URL.
+func (b *Backend) AssertPurged(t testing.TB, url string) bool {
+	t.Helper()
+
+	for _, req := range b.Requests() {
+		if req.URL() == url {
+			return true
+		}
+	}
+
+	t.Errorf("purgerytest: %q wasn't purged", url)
+
+	return false
+}
+
+// AssertNotPurged is the inverse of AssertPurged.
+func (b *Backend) AssertNotPurged(t testing.TB, url string) bool {
+	t.Helper()
+
+	for _, req := range b.Requests() {
+		if req.URL() == url {
+			t.Errorf("purgerytest: %q was purged", url)
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// AssertPurgeCount reports, and fails t when it isn't the case, whether the
+// Backend has received exactly n purge requests.
+func (b *Backend) AssertPurgeCount(t testing.TB, n int) bool {
+	t.Helper()
+
+	if got := len(b.Requests()); got != n {
+		t.Errorf("purgerytest: expected %d purge requests; got %d", n, got)
+
+		return false
+	}
+
+	return true
+}
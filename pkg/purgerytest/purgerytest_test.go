@@ -0,0 +1,99 @@
+package purgerytest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/purge"
+	"github.com/soupedup/purgery/pkg/client"
+)
+
+func TestServer(t *testing.T) {
+	srv := NewServer("123")
+	defer srv.Close()
+
+	c := srv.Client()
+	ctx := context.Background()
+
+	id, err := c.Enqueue(ctx, "http://example.com/a")
+	require.NoError(t, err)
+
+	st, err := c.Status(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/a", st.URL)
+
+	srv.FailNext(1, http.StatusInternalServerError)
+	assert.Error(t, c.Purge(ctx, "http://example.com/b"))
+	assert.NoError(t, c.Purge(ctx, "http://example.com/c"))
+
+	assert.Error(t, c.Purge(ctx, "ftp://example.com/"))
+
+	srv.FailAll(http.StatusTeapot)
+	assert.Error(t, c.Purge(ctx, "http://example.com/d"))
+	srv.FailAll(0)
+
+	assert.Equal(t, []string{"http://example.com/a", "http://example.com/c"}, srv.URLs())
+
+	assert.Error(t, client.New(srv.URL, "wrong").Purge(ctx, "http://example.com/e"))
+	assert.Len(t, srv.Requests(), 2)
+
+	srv.Reset()
+	assert.Empty(t, srv.Requests())
+}
+
+// recorder implements a testing.TB which records its failures.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestBackend(t *testing.T) {
+	b := NewBackend()
+	defer b.Close()
+
+	fn := purge.New(b.Addr())
+	ctx := context.Background()
+
+	require.NoError(t, fn(ctx, zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/a"}))
+
+	b.Respond(http.StatusForbidden)
+	require.Error(t, fn(ctx, zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/b", Scope: cache.ScopeExact}))
+
+	assert.True(t, b.AssertPurged(t, "http://example.com/a"))
+	assert.True(t, b.AssertNotPurged(t, "http://example.com/c"))
+	assert.True(t, b.AssertPurgeCount(t, 2))
+	assert.Equal(t, cache.ScopeExact, b.Requests()[1].Header.Get(purge.ScopeHeader))
+
+	r := &recorder{TB: t}
+	assert.False(t, b.AssertPurged(r, "http://example.com/c"))
+	assert.False(t, b.AssertNotPurged(r, "http://example.com/a"))
+	assert.False(t, b.AssertPurgeCount(r, 3))
+	assert.Len(t, r.errors, 3)
+
+	b.Reset()
+	assert.True(t, b.AssertPurgeCount(t, 0))
+}
+
+func TestListenBackend(t *testing.T) {
+	b, err := ListenBackend("127.0.0.1:0")
+	require.NoError(t, err)
+	defer b.Close()
+
+	fn := purge.New(b.Addr())
+	require.NoError(t, fn(context.Background(), zap.NewNop(), &cache.PurgeRequest{URL: "http://example.com/a"}))
+
+	assert.True(t, b.AssertPurged(t, "http://example.com/a"))
+}
@@ -0,0 +1,216 @@
+// Package purgerytest implements fakes of purgery and of the caches it purges,
+// for use in tests which would otherwise require Redis or Varnish.
+package purgerytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soupedup/purgery/pkg/client"
+)
+
+// Server implements an in-memory fake of the purgery REST API, which records
+// the purge requests it accepts.
+//
+// Instances of Server are safe for concurrent use.
+type Server struct {
+	// URL holds the root URL of the Server, as expected by client.New.
+	URL string
+
+	srv    *httptest.Server
+	apiKey string
+
+	mu       sync.Mutex
+	requests []Request
+	failures []int // status codes of the requests to fail
+	failAll  int
+}
+
+// Request wraps a purge request a Server has accepted.
+type Request struct {
+	client.PurgeRequest
+
+	// ID holds the ID the Server reported for the purge request.
+	ID string
+
+	// Time holds the time the Server accepted the purge request at.
+	Time time.Time
+}
+
+// NewServer starts and returns a Server. Unless apiKey is empty, the Server
+// requires requests to authenticate with it, as client.New does.
+//
+// Callers should Close the Server when done with it.
+func NewServer(apiKey string) *Server {
+	s := &Server{apiKey: apiKey}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+
+	return s
+}
+
+// Client returns a client which targets the Server.
+func (s *Server) Client() *client.Client {
+	return client.New(s.URL, s.apiKey)
+}
+
+// Close shuts the Server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Requests returns the purge requests the Server has accepted, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// URLs returns the URLs of the purge requests the Server has accepted, in
+// order.
+func (s *Server) URLs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	urls := make([]string, len(s.requests))
+	for i := range s.requests {
+		urls[i] = s.requests[i].URL
+	}
+
+	return urls
+}
+
+// FailNext makes the Server respond to the next n purge requests with the
+// given status code.
+func (s *Server) FailNext(n, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, code)
+	}
+}
+
+// FailAll makes the Server respond to all purge requests with the given status
+// code, until FailAll is called with 0.
+func (s *Server) FailAll(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failAll = code
+}
+
+// Reset forgets the purge requests the Server has accepted and the failures
+// it's been told to inject.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests, s.failures, s.failAll = nil, nil, 0
+}
+
+// ServeHTTP implements http.Handler for Server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.apiKey != "" {
+		if key, _, ok := r.BasicAuth(); !ok || key != s.apiKey {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+	}
+
+	switch {
+	case r.URL.Path == "/health" && r.Method == http.MethodGet:
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/purge" && r.Method == http.MethodPost:
+		s.purge(w, r)
+	case strings.HasPrefix(r.URL.Path, "/purges/") && r.Method == http.MethodGet:
+		s.status(w, strings.TrimPrefix(r.URL.Path, "/purges/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// failure returns the status code the current request should fail with, if
+// any; callers must hold the lock.
+func (s *Server) failure() int {
+	if s.failAll != 0 {
+		return s.failAll
+	}
+
+	if len(s.failures) > 0 {
+		code := s.failures[0]
+		s.failures = s.failures[1:]
+
+		return code
+	}
+
+	return 0
+}
+
+func (s *Server) purge(w http.ResponseWriter, r *http.Request) {
+	var pr client.PurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&pr); err != nil || !isValidURL(pr.URL) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if code := s.failure(); code != 0 {
+		w.WriteHeader(code)
+
+		return
+	}
+
+	now := time.Now()
+	id := strconv.FormatInt(now.UnixMilli(), 10) + "-" + strconv.Itoa(len(s.requests))
+
+	s.requests = append(s.requests, Request{
+		PurgeRequest: pr,
+		ID:           id,
+		Time:         now,
+	})
+
+	w.Header().Set("Location", "/purges/"+id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) status(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, req := range s.requests {
+		if req.ID != id {
+			continue
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(client.Status{
+			ID:        req.ID,
+			Time:      req.Time.UTC(),
+			URL:       req.URL,
+			Scope:     req.Scope,
+			Instances: []client.InstanceStatus{},
+		})
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func isValidURL(rawurl string) bool {
+	u, err := url.Parse(rawurl)
+
+	return rawurl != "" && err == nil && u.Scheme == "http"
+}
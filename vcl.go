@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/azazeal/exit"
+
+	"github.com/soupedup/purgery/internal/cache"
+	"github.com/soupedup/purgery/internal/common"
+	"github.com/soupedup/purgery/internal/vcl"
+)
+
+// runVCL implements the vcl command, which renders the VCL which applies the
+// purge requests purgery sends.
+func runVCL(args []string) error {
+	fs := newFlagSet("vcl", "")
+
+	var (
+		scopes = fs.String("scopes", cache.ScopeHost,
+			"comma-separated purge scopes to apply ("+strings.Join(cache.Scopes, ", ")+")")
+		soft    = fs.Bool("soft", false, "apply soft purges of exact- and xkey-scoped purges as such")
+		token   = fs.String("token", "", "token purge requests must carry (VARNISH_TOKEN)")
+		acl     = fs.String("acl", "", "comma-separated addresses and networks purge requests must originate from")
+		backend = fs.String("backend", "nginx:80", "host:port address of the backend")
+		legacy  = fs.Bool("legacy-bans", true, "also ban, by host, objects cached by the VCL purgery shipped before this command")
+	)
+
+	if err := parseFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	err := vcl.Render(os.Stdout, &vcl.Config{
+		Scopes:     splitList(*scopes),
+		Soft:       *soft,
+		Token:      *token,
+		ACL:        splitList(*acl),
+		Backend:    *backend,
+		LegacyBans: *legacy,
+	})
+	if err != nil {
+		return exit.Wrap(common.ECUsage, fail(err))
+	}
+
+	return nil
+}
+
+// splitList splits the given comma-separated list, ignoring empty elements.
+func splitList(s string) (list []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return
+}